@@ -7,7 +7,7 @@ import { useParams } from "next/navigation";
 import { LucideChevronLeft } from "lucide-react";
 import { toast } from "sonner";
 import AudioPlayer from "@/components/audioPlayer";
-import { cn } from "@/lib/utils";
+import { cn, authHeaders } from "@/lib/utils";
 import { useRouter } from "next/navigation";
 // import { Skeleton } from "@/components/ui/skeleton";
 import { TrackCard } from "@/components/trackCard";
@@ -46,7 +46,7 @@ const PlaylistPage = () => {
         method: "POST",
         headers: {
           "Content-Type": "application/json",
-          // Authorization: `Bearer ${accessToken}`,
+          ...authHeaders(),
         },
         body: JSON.stringify({ videoIds: firstFewTrackIds }),
       },
@@ -61,6 +61,7 @@ const PlaylistPage = () => {
       method: "POST",
       headers: {
         "Content-Type": "application/json",
+        ...authHeaders(),
       },
       body: JSON.stringify({ videoIds: remainingTrackIds }),
     }).then((response) => {
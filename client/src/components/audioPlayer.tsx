@@ -13,6 +13,7 @@ import {
 import { TRACK } from "@/types/playlist";
 import { toast } from "sonner";
 import { AnimatePresence, motion as m } from "motion/react";
+import { streamUrl } from "@/lib/utils";
 
 interface AudioPlayerProps {
   VideoIds: string[];
@@ -43,7 +44,7 @@ const AudioPlayer = ({
       return;
     }
 
-    const audioUrl = `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${videoId}`;
+    const audioUrl = streamUrl(videoId);
 
     if (audioRef.current) {
       setIsLoaded(false);
@@ -17,7 +17,7 @@ import {
 import { Plus, Loader2 } from "lucide-react";
 import { toast } from "sonner";
 import { YOUTUBE_DATA } from "@/types/youtubeData";
-import { cn } from "@/lib/utils";
+import { cn, authHeaders } from "@/lib/utils";
 import { Skeleton } from "./ui/skeleton";
 import { SearchSelect } from "./searchSelect";
 
@@ -63,7 +63,7 @@ export const SearchPopup = ({
           `${process.env.NEXT_PUBLIC_BACKEND_URL}/search`,
           {
             method: "POST",
-            headers: { "Content-Type": "application/json" },
+            headers: { "Content-Type": "application/json", ...authHeaders() },
             body: JSON.stringify({ query }),
           },
         );
@@ -83,7 +83,7 @@ export const SearchPopup = ({
           `${process.env.NEXT_PUBLIC_BACKEND_URL}/youtube/basic-search`,
           {
             method: "POST",
-            headers: { "Content-Type": "application/json" },
+            headers: { "Content-Type": "application/json", ...authHeaders() },
             body: JSON.stringify({ query }),
           },
         );
@@ -118,7 +118,7 @@ export const SearchPopup = ({
         `${process.env.NEXT_PUBLIC_BACKEND_URL}/transify`,
         {
           method: "POST",
-          headers: { "Content-Type": "application/json" },
+          headers: { "Content-Type": "application/json", ...authHeaders() },
           body: JSON.stringify({ videoIds: [id] }),
         },
       );
@@ -8,6 +8,7 @@ import { Badge } from "./badge";
 import { Users, Music, Clock } from "lucide-react";
 import { SearchPopup } from "../searchPopup";
 import { Button } from "./button";
+import { streamUrl } from "@/lib/utils";
 
 type USER = {
   ID: string;
@@ -23,6 +24,35 @@ type LISTENER = {
   Email: string;
 };
 
+// Song and PlaybackState as the server sends them
+type ROOM_SONG = {
+  Title: string;
+  VideoID: string;
+  Artists?: string[];
+  Thumbnail?: string;
+};
+
+// How long to wait before reconnecting a dropped socket
+const RECONNECT_DELAY_MS = 2000;
+
+// How far a listener may drift from the room before sync moves them
+const MAX_DRIFT_MS = 300;
+
+type PLAYBACK = {
+  videoId: string;
+  positionMs: number;
+  playing: boolean;
+  updatedAt: number;
+};
+
+const toQueue = (songs?: ROOM_SONG[]): YOUTUBE_DATA[] =>
+  (songs || []).map((song) => ({
+    YT_TITLE: song.Title,
+    YT_VIDEO_ID: song.VideoID,
+    YT_ARTISTS: song.Artists,
+    YT_IMAGE: song.Thumbnail,
+  }));
+
 const RoomPlayerPage = ({
   roomID,
   isHost,
@@ -32,8 +62,16 @@ const RoomPlayerPage = ({
 }) => {
   const audioRef = useRef<HTMLAudioElement | null>(null);
   const wsRef = useRef<WebSocket | null>(null);
+  const userRef = useRef<USER | null>(null);
+  // session and lastSeq let a dropped connection resume where it was
+  const sessionRef = useRef<string>("");
+  const lastSeqRef = useRef<number>(0);
+  const videoIdRef = useRef<string>("");
   const [currentSongIdx, setCurrentSongIdx] = useState<number>(0);
   const [user, setUser] = useState<USER | null>(null);
+  // isHost is the role asked for; the server says whether we actually are
+  const [amHost, setAmHost] = useState<boolean>(isHost);
+  const [hostID, setHostID] = useState<string>("");
   const [listeners, setListeners] = useState<LISTENER[]>([]);
   const [songQueue, setSongQueue] = useState<YOUTUBE_DATA[]>([
     // {
@@ -61,25 +99,50 @@ const RoomPlayerPage = ({
   // Connect WebSocket
   useEffect(() => {
     const protocol = window.location.protocol === "https:" ? "wss" : "ws";
-    const ws = new WebSocket(
-      `${protocol}://${process.env.NEXT_PUBLIC_BACKEND_HOSTNAME}/ws/${roomID}/${isHost ? "host" : "guest"}`,
-    );
-    wsRef.current = ws;
+    // Set once we leave on purpose, so the close isn't taken for a drop
+    let leaving = false;
+    let reconnectTimer: ReturnType<typeof setTimeout> | undefined;
 
     const token = localStorage.getItem("googleAccessToken");
 
-    ws.onopen = () => {
-      console.log("Connected to WebSocket ✅");
-      if (token) {
-        ws.send(JSON.stringify({ type: "auth", token }));
-      } else {
-        toast.error("Unauthorized");
+    // Puts the audio where the room is: same song, position projected from
+    // when the server took the snapshot, playing or paused. Small drift is
+    // left alone so periodic syncs don't make the audio skip.
+    const applyPlayback = (pb: PLAYBACK | undefined, serverTime?: number) => {
+      const audio = audioRef.current;
+      if (!audio || !pb?.videoId) return;
+
+      const elapsed =
+        pb.playing && serverTime ? Math.max(0, serverTime - pb.updatedAt) : 0;
+      const positionMs = pb.positionMs + elapsed;
+
+      if (videoIdRef.current !== pb.videoId) {
+        videoIdRef.current = pb.videoId;
+        audio.src = streamUrl(pb.videoId);
+        audio.currentTime = positionMs / 1000;
+      } else if (
+        Math.abs(audio.currentTime * 1000 - positionMs) > MAX_DRIFT_MS
+      ) {
+        audio.currentTime = positionMs / 1000;
+      }
+
+      if (pb.playing && audio.paused) {
+        audio.play().catch(() => {
+          toast.message("Press play to join the room's audio");
+        });
+      } else if (!pb.playing && !audio.paused) {
+        audio.pause();
       }
     };
 
-    ws.onmessage = (event) => {
-      const data = JSON.parse(event.data);
+    const handleMessage = (event: MessageEvent) => {
+      // Every frame is an envelope { v, id, type, seq, payload }
+      const envelope = JSON.parse(event.data);
+      const data = { type: envelope.type, ...envelope.payload };
       console.log("Received WS event:", data);
+      if (envelope.seq) {
+        lastSeqRef.current = Math.max(lastSeqRef.current, envelope.seq);
+      }
 
       if (!audioRef.current) {
         console.log("failed to initialize audio ref", audioRef);
@@ -89,48 +152,63 @@ const RoomPlayerPage = ({
       switch (data.type) {
         case "play": {
           const videoId = data.song?.VideoID;
-          if (!videoId) break;
-
-          const audioSrc = `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${videoId}`;
-          audioRef.current.src = audioSrc;
-
-          setSongQueue((prevQueue) => {
-            const existingIndex = prevQueue.findIndex(
-              (s) => s.YT_VIDEO_ID === videoId,
-            );
-            if (existingIndex === -1) {
-              const newSong: YOUTUBE_DATA = {
-                YT_TITLE: data.song?.YT_TITLE || data.song?.Title || "",
-                YT_VIDEO_ID: videoId,
-              };
-              const updated = [...prevQueue, newSong];
-              setCurrentSongIdx(updated.length - 1);
-              return updated;
-            }
-            setCurrentSongIdx(existingIndex);
-            return prevQueue;
-          });
+          if (videoId) {
+            setSongQueue((prevQueue) => {
+              const existingIndex = prevQueue.findIndex(
+                (s) => s.YT_VIDEO_ID === videoId,
+              );
+              if (existingIndex === -1) {
+                const newSong: YOUTUBE_DATA = {
+                  YT_TITLE: data.song?.YT_TITLE || data.song?.Title || "",
+                  YT_VIDEO_ID: videoId,
+                };
+                const updated = [...prevQueue, newSong];
+                setCurrentSongIdx(updated.length - 1);
+                return updated;
+              }
+              setCurrentSongIdx(existingIndex);
+              return prevQueue;
+            });
+          }
 
-          audioRef.current.play();
+          if (data.playback) {
+            applyPlayback(data.playback, data.serverTime);
+          } else if (videoId) {
+            videoIdRef.current = videoId;
+            audioRef.current.src = streamUrl(videoId);
+            audioRef.current.play();
+          }
           break;
         }
 
         case "pause":
-          audioRef.current.pause();
+          if (data.playback) {
+            applyPlayback(data.playback, data.serverTime);
+          } else {
+            audioRef.current.pause();
+          }
+          break;
+
+        case "seek":
+          applyPlayback(data.playback, data.serverTime);
           break;
 
         case "next":
         case "previous":
           if (data.song?.VideoID) {
             const vid = data.song.VideoID;
-            const audioSrc = `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${vid}`;
-            audioRef.current.src = audioSrc;
             setSongQueue((prev) => {
               const idx = prev.findIndex((song) => song.YT_VIDEO_ID === vid);
               if (idx !== -1) setCurrentSongIdx(idx);
               return prev;
             });
-            audioRef.current.play();
+            if (data.playback) {
+              applyPlayback(data.playback, data.serverTime);
+            } else {
+              videoIdRef.current = vid;
+              audioRef.current.src = streamUrl(vid);
+              audioRef.current.play();
+            }
           }
           break;
 
@@ -139,15 +217,71 @@ const RoomPlayerPage = ({
             const vid = data.song?.VideoID || data.song?.YT_VIDEO_ID;
             const title = data.song?.Title || data.song?.YT_TITLE;
             if (!vid) return prev;
+            // While shuffling the server puts new songs anywhere among
+            // the ones to come; index says where
+            if (typeof data.index === "number") {
+              const [song] = toQueue([data.song]);
+              return [
+                ...prev.slice(0, data.index),
+                song,
+                ...prev.slice(data.index),
+              ];
+            }
             const exists = prev.some((s) => s.YT_VIDEO_ID === vid);
             if (exists) return prev;
             return [...prev, { YT_TITLE: title, YT_VIDEO_ID: vid }];
           });
           break;
 
+        case "play_mode_changed":
+          // Turning shuffle on or off reorders the songs to come and sends
+          // the queue; a repeat change sends neither
+          if (data.currentSongIdx !== undefined) {
+            setSongQueue(toQueue(data.queue));
+            setCurrentSongIdx(data.currentSongIdx ?? -1);
+          }
+          break;
+
         case "auth_success":
+          userRef.current = data.user;
+          sessionRef.current = data.session || "";
           setUser(data.user);
+          setAmHost(!!data.isHost);
+          if (data.isHost) setHostID(data.user?.ID || "");
+          break;
+
+        case "room_state": {
+          const room = data.room;
+          if (!room) break;
+          setSongQueue(toQueue(room.songsQueue));
+          setCurrentSongIdx(room.currentSongIdx);
+          setHostID(room.host?.ID || "");
+          applyPlayback(room.playback, data.serverTime);
+          break;
+        }
+
+        case "sync":
+          applyPlayback(data.playback, data.serverTime);
+          break;
+
+        case "queue_updated":
+          // The queue is left out when it's empty
+          setSongQueue(toQueue(data.queue));
+          setCurrentSongIdx(data.currentSongIdx ?? -1);
+          break;
+
+        case "host_changed": {
+          const newHostID = data.user?.ID || "";
+          const isMe = !!newHostID && newHostID === userRef.current?.ID;
+          setHostID(newHostID);
+          setAmHost(isMe);
+          if (isMe) {
+            toast.success("You're the host now");
+          } else if (data.user) {
+            toast.message(`${data.user.Name} is the host now`);
+          }
           break;
+        }
 
         case "all_users": {
           const uniqueUsers = (data.users || []).reduce(
@@ -188,9 +322,45 @@ const RoomPlayerPage = ({
           break;
       }
     };
+    const connect = () => {
+      const ws = new WebSocket(
+        `${protocol}://${process.env.NEXT_PUBLIC_BACKEND_HOSTNAME}/ws/${roomID}/${isHost ? "host" : "guest"}`,
+      );
+      wsRef.current = ws;
+
+      ws.onopen = () => {
+        console.log("Connected to WebSocket ✅");
+        if (!token) {
+          toast.error("Unauthorized");
+          return;
+        }
+        // A reconnect resumes the old session and gets what it missed
+        const payload = sessionRef.current
+          ? { token, session: sessionRef.current, lastSeq: lastSeqRef.current }
+          : { token };
+        ws.send(JSON.stringify({ v: 1, type: "auth", payload }));
+      };
+
+      ws.onmessage = handleMessage;
+
+      ws.onclose = (event) => {
+        // 1000 from the server is a goodbye (room closed, turned away),
+        // not a drop worth retrying
+        if (leaving || event.code === 1000) return;
+        reconnectTimer = setTimeout(connect, RECONNECT_DELAY_MS);
+      };
+    };
+
+    connect();
+
     return () => {
-      ws.close();
+      leaving = true;
+      clearTimeout(reconnectTimer);
+      // 1000 tells the server we left, so it doesn't hold our place
+      wsRef.current?.close(1000);
       wsRef.current = null;
+      sessionRef.current = "";
+      lastSeqRef.current = 0;
     };
   }, [roomID, isHost]);
 
@@ -205,10 +375,15 @@ const RoomPlayerPage = ({
     if (wsRef.current?.readyState === WebSocket.OPEN) {
       wsRef.current.send(
         JSON.stringify({
+          v: 1,
           type,
-          song: {
-            Title: song?.YT_TITLE,
-            VideoID: song?.YT_VIDEO_ID,
+          payload: song && {
+            song: {
+              Title: song.YT_TITLE,
+              VideoID: song.YT_VIDEO_ID,
+              Artists: song.YT_ARTISTS,
+              Thumbnail: song.YT_IMAGE,
+            },
           },
         }),
       );
@@ -221,7 +396,8 @@ const RoomPlayerPage = ({
     const nextSong = songQueue[nextIndex];
     setCurrentSongIdx(nextIndex);
     if (audioRef.current) {
-      audioRef.current.src = `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${nextSong.YT_VIDEO_ID}`;
+      videoIdRef.current = nextSong.YT_VIDEO_ID;
+      audioRef.current.src = streamUrl(nextSong.YT_VIDEO_ID);
       audioRef.current.play();
     }
     sendEvent("play", nextSong);
@@ -233,7 +409,8 @@ const RoomPlayerPage = ({
     const prevSong = songQueue[prevIndex];
     setCurrentSongIdx(prevIndex);
     if (audioRef.current) {
-      audioRef.current.src = `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${prevSong.YT_VIDEO_ID}`;
+      videoIdRef.current = prevSong.YT_VIDEO_ID;
+      audioRef.current.src = streamUrl(prevSong.YT_VIDEO_ID);
       audioRef.current.play();
     }
     sendEvent("play", prevSong);
@@ -242,7 +419,8 @@ const RoomPlayerPage = ({
   const handlePlay = () => {
     const song = songQueue[currentSongIdx];
     if (audioRef.current && song) {
-      audioRef.current.src = `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${song.YT_VIDEO_ID}`;
+      videoIdRef.current = song.YT_VIDEO_ID;
+      audioRef.current.src = streamUrl(song.YT_VIDEO_ID);
       audioRef.current.play();
     }
     sendEvent("play", song);
@@ -252,7 +430,7 @@ const RoomPlayerPage = ({
     if (audioRef.current) {
       audioRef.current.pause();
     }
-    sendEvent("pause", songQueue[currentSongIdx]);
+    sendEvent("pause");
   };
 
   return (
@@ -285,7 +463,7 @@ const RoomPlayerPage = ({
                     <Users className="w-4 h-4 mr-2" />
                     {listeners.length} Listeners
                   </Badge>
-                  {isHost && (
+                  {amHost && (
                     <Badge
                       variant="secondary"
                       className="bg-yellow-600/20 text-yellow-400 border-yellow-500/30"
@@ -307,10 +485,11 @@ const RoomPlayerPage = ({
                     <button
                       key={song.YT_VIDEO_ID + idx}
                       onClick={() => {
-                        if (!isHost) return;
+                        if (!amHost) return;
                         setCurrentSongIdx(idx);
                         if (audioRef.current) {
-                          audioRef.current.src = `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${song.YT_VIDEO_ID}`;
+                          videoIdRef.current = song.YT_VIDEO_ID;
+                          audioRef.current.src = streamUrl(song.YT_VIDEO_ID);
                           audioRef.current.play();
                         }
                         sendEvent("play", song);
@@ -359,7 +538,9 @@ const RoomPlayerPage = ({
                 {listeners.length > 0 ? (
                   listeners.map((listener) => {
                     const isCurrentUser = user?.ID === listener.ID;
-                    const isHostUser = isCurrentUser && isHost;
+                    const isHostUser = hostID
+                      ? listener.ID === hostID
+                      : isCurrentUser && amHost;
 
                     return (
                       <div
@@ -405,7 +586,7 @@ const RoomPlayerPage = ({
             <div className="mx-auto max-w-7xl w-full">
               <RoomAudioPlayer
                 ref={audioRef}
-                isHost={isHost}
+                isHost={amHost}
                 currentSong={songQueue[currentSongIdx]}
                 onPlay={handlePlay}
                 onPause={handlePause}
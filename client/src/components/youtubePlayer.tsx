@@ -15,6 +15,7 @@ import { motion as m } from "motion/react";
 import { YOUTUBE_DATA } from "@/types/youtubeData";
 import { SearchPopup } from "./searchPopup";
 import { Slider } from "./ui/slider";
+import { streamUrl } from "@/lib/utils";
 
 interface YoutubePlayerProps {
   track?: YOUTUBE_DATA;
@@ -43,7 +44,7 @@ const YoutubePlayer = ({
       return;
     }
 
-    const audioUrl = `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${videoId}`;
+    const audioUrl = streamUrl(videoId);
     if (audioRef.current) {
       setIsLoaded(false);
       audioRef.current.src = audioUrl;
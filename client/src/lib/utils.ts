@@ -24,3 +24,15 @@ export function ClearLocalStorage() {
   toast.success("Local Storage Cleared");
   window.location.reload();
 }
+
+// The backend wants the signed-in user's token on every request
+export function authHeaders(): Record<string, string> {
+  const token = localStorage.getItem("googleAccessToken");
+  return token ? { Authorization: `Bearer ${token}` } : {};
+}
+
+// Audio elements can't send headers, so the stream takes the token in the URL
+export function streamUrl(videoId: string): string {
+  const token = localStorage.getItem("googleAccessToken") ?? "";
+  return `${process.env.NEXT_PUBLIC_BACKEND_URL}/stream/${videoId}?token=${encodeURIComponent(token)}`;
+}
@@ -2,6 +2,7 @@ import usePlaylistStore from "@/store/playlistStore";
 import { YOUTUBE_DATA } from "@/types/youtubeData";
 // import router from "next/dist/client/router";
 import { toast } from "sonner";
+import { authHeaders } from "@/lib/utils";
 
 //search via youtube data api
 export async function getYoutubeVideoId(
@@ -62,6 +63,7 @@ export async function getPlaylistYTData(
       method: "POST",
       headers: {
         "Content-Type": "application/json",
+        ...authHeaders(),
       },
       body: JSON.stringify({ query }),
     },
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"Vybe/services"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// userKey is where Auth keeps the caller in the request's locals
+type userKey struct{}
+
+var errMissingToken = errors.New("missing token")
+
+// bearerToken reads the token from the Authorization header, or from
+// ?token= when allowQuery is set
+func bearerToken(c *fiber.Ctx, allowQuery bool) (string, error) {
+	if token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok && token != "" {
+		return strings.TrimSpace(token), nil
+	}
+	if allowQuery && c.Query("token") != "" {
+		return c.Query("token"), nil
+	}
+	return "", errMissingToken
+}
+
+// routeMatcher is an allow-list entry: a method (empty for any) and a path,
+// which matches everything below it when it ends in /*
+type routeMatcher struct {
+	method string
+	path   string
+	prefix bool
+}
+
+func parseRoute(entry string) routeMatcher {
+	var r routeMatcher
+	if method, path, ok := strings.Cut(entry, " "); ok {
+		r.method, entry = method, path
+	}
+	if path, ok := strings.CutSuffix(entry, "/*"); ok {
+		r.path, r.prefix = path+"/", true
+	} else {
+		r.path = entry
+	}
+	return r
+}
+
+func (r routeMatcher) matches(method, path string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+	if r.prefix {
+		return strings.HasPrefix(path, r.path)
+	}
+	return path == r.path
+}
+
+func parseRoutes(entries []string) []routeMatcher {
+	routes := make([]routeMatcher, len(entries))
+	for i, entry := range entries {
+		routes[i] = parseRoute(entry)
+	}
+	return routes
+}
+
+func matchesAny(routes []routeMatcher, c *fiber.Ctx) bool {
+	for _, r := range routes {
+		if r.matches(c.Method(), c.Path()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Auth requires a valid bearer token and attaches its user to the request;
+// read it back with CurrentUser. Routes in the public allow-list, written
+// like "GET /rooms/*", let anonymous callers through, though a valid token
+// is still attached. Routes in queryToken may pass the token as ?token=
+// instead of a header, for media elements that can't set headers; nothing
+// else may, so tokens stay out of URLs and logs.
+func Auth(public, queryToken []string) fiber.Handler {
+	publicRoutes := parseRoutes(public)
+	queryRoutes := parseRoutes(queryToken)
+
+	return func(c *fiber.Ctx) error {
+		isPublic := matchesAny(publicRoutes, c)
+
+		token, err := bearerToken(c, matchesAny(queryRoutes, c))
+		if err != nil {
+			if isPublic {
+				return c.Next()
+			}
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing token"})
+		}
+
+		user, err := services.ParseJWT(token)
+		if err != nil {
+			// Public routes may carry someone else's token, e.g. Spotify's
+			if isPublic {
+				return c.Next()
+			}
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
+		}
+
+		c.Locals(userKey{}, user)
+		return c.Next()
+	}
+}
+
+// CurrentUser returns the user Auth attached to the request, or nil on a
+// public route called anonymously
+func CurrentUser(c *fiber.Ctx) *services.User {
+	user, _ := c.Locals(userKey{}).(*services.User)
+	return user
+}
+
+// RateLimit limits a route per signed-in user, or per IP for anonymous
+// callers, answering 429 with Retry-After once they run out. It goes after
+// Auth so it can tell who is calling.
+func RateLimit(limiter *services.RateLimiter, route string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := "ip:" + c.IP()
+		if user := CurrentUser(c); user != nil {
+			key = "user:" + user.ID
+		}
+
+		ok, retryAfter := limiter.AllowRoute(route, key)
+		if !ok {
+			log.Printf("Rate limited %s on %s", key, route)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many requests, try again later"})
+		}
+
+		return c.Next()
+	}
+}
+
+// ValidRoomID rejects a :roomID the room store can't safely key by
+func ValidRoomID(c *fiber.Ctx) error {
+	if !services.ValidRoomID(c.Params("roomID")) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid room ID"})
+	}
+	return c.Next()
+}
@@ -0,0 +1,495 @@
+package handlers
+
+import (
+	"Vybe/services"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var (
+	errAlreadyAuthenticated = services.NewProtocolError(services.CodeBadRequest, "already authenticated")
+	errNotAuthenticated     = services.NewProtocolError(services.CodeUnauthorized, "send auth first")
+	errInvalidToken         = services.NewProtocolError(services.CodeUnauthorized, "invalid or expired token")
+	errNotAllowed           = services.NewProtocolError(services.CodeForbidden, "your role doesn't allow that")
+)
+
+func rateLimited(retryAfter time.Duration) error {
+	err := services.NewProtocolError(services.CodeRateLimited, "slow down")
+	err.RetryAfterMs = retryAfter.Milliseconds()
+	return err
+}
+
+// rejected reports a request the hub couldn't carry out
+func rejected(what string) error {
+	return services.NewProtocolError(services.CodeRejected, "could not %s", what)
+}
+
+// RoomSocket handles /ws/:roomID/:role, relaying room events through the hub
+func RoomSocket(hub *services.Hub, limiter *services.RateLimiter) fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		roomID := c.Params("roomID")
+		role := c.Params("role")
+
+		enc := services.NegotiateEncoding(c.Subprotocol(), c.Query("encoding"))
+		client := services.NewClient(uuid.NewString(), c, roomID, role == "host", enc)
+
+		// The client only joins the room (and starts receiving broadcasts)
+		// once it has authenticated and been let in
+		authenticated := false
+		// A clean close means the user left; anything else may be a blip
+		// they'll reconnect from
+		left := false
+		defer func() {
+			hub.Disconnect(roomID, client, left)
+			client.Close()
+		}()
+		log.Printf("User %s connected to room %s", client.Conn.RemoteAddr(), roomID)
+
+		for {
+			messageType, msg, err := c.ReadMessage()
+			if err != nil {
+				log.Printf("Error reading message: %v", err)
+				left = websocket.IsCloseError(err, websocket.CloseNormalClosure)
+				break
+			}
+			select {
+			case <-client.Done():
+				// Stopped, e.g. evicted as a slow consumer; nothing it sends
+				// counts any more
+				return
+			default:
+			}
+			client.Touch()
+
+			req, err := services.DecodeFrame(messageType == websocket.BinaryMessage, msg)
+			if err != nil {
+				log.Printf("Invalid event: %v", err)
+				hub.SendError(client, req.ID, err)
+				continue
+			}
+
+			if !authenticated {
+				auth, ok := req.Payload.(*services.AuthPayload)
+				if !ok {
+					hub.SendError(client, req.ID, errNotAuthenticated)
+					continue
+				}
+				if !authenticate(hub, limiter, client, req.ID, auth) {
+					return
+				}
+				authenticated = true
+				continue
+			}
+
+			if !hub.Allowed(roomID, client, req.Type) {
+				log.Printf("User %s is not allowed to send %s", client.User.Name, req.Type)
+				hub.SendError(client, req.ID, errNotAllowed)
+				continue
+			}
+
+			if ok, retryAfter := limiter.AllowEvent(roomID, client.User.ID, req.Type); !ok {
+				log.Printf("User %s is sending %s too fast", client.User.Name, req.Type)
+				hub.SendError(client, req.ID, rateLimited(retryAfter))
+				continue
+			}
+
+			if err := handleRequest(hub, client, req); err != nil {
+				hub.SendError(client, req.ID, err)
+			} else if req.ID != "" {
+				hub.Ack(client, req.ID)
+			}
+		}
+	}, websocket.Config{
+		// Clients opt into a binary encoding by subprotocol
+		Subprotocols: services.Subprotocols,
+	})
+}
+
+// authenticate checks the token and lets the client into the room, either
+// resuming its old session or through the door. It reports false when the
+// connection should be closed; the client has already been told why.
+func authenticate(hub *services.Hub, limiter *services.RateLimiter, client *services.Client, id string, auth *services.AuthPayload) bool {
+	roomID := client.Room
+
+	user, err := services.ParseJWT(auth.Token)
+	if err != nil {
+		log.Printf("Auth failed : %v", err)
+		hub.SendError(client, id, errInvalidToken)
+		return false
+	}
+
+	client.User = user
+	log.Printf("User %s authenticated", user.Name)
+
+	// A dropped connection coming back within the grace period skips the
+	// door and picks up where it was
+	isHost, resumed := false, false
+	if auth.Session != "" {
+		isHost, resumed = hub.ResumeSession(roomID, client, auth.Session)
+	}
+
+	if !resumed {
+		if !admit(hub, limiter, roomID, client, id, auth) {
+			return false
+		}
+		// Pongs weren't read while knocking; restart the clock
+		client.Touch()
+	}
+
+	hub.JoinRoom(roomID, client)
+	if !resumed {
+		isHost = hub.AddMember(roomID, client)
+	}
+
+	hub.Reply(client, id, "auth_success", services.AuthSuccessPayload{
+		User:    user,
+		IsHost:  isHost,
+		Role:    hub.RoleOf(roomID, client),
+		Session: client.Session,
+		Resumed: resumed,
+	})
+
+	if resumed {
+		// Nobody saw them go, so there's no join to announce; just fill in
+		// what they missed
+		var lastSeq int64
+		if auth.LastSeq != nil {
+			lastSeq = *auth.LastSeq
+		}
+		hub.CatchUp(client, lastSeq)
+		hub.SendSync(client)
+		return true
+	}
+
+	// Catch the newcomer up on queue, host and playback, then line their
+	// clock up with the room
+	hub.SendRoomState(client)
+	hub.SendSync(client)
+	hub.SendChatHistory(client)
+
+	// Notify other users that someone joined
+	hub.NotifyUserJoin(roomID, user)
+	// Send current user list to the newly joined user
+	hub.BroadcastUsers(roomID)
+	return true
+}
+
+// handleRequest carries out one request from an authenticated client. A nil
+// result is acked to the sender, an error is sent back to them.
+func handleRequest(hub *services.Hub, client *services.Client, req services.Request) error {
+	roomID := client.Room
+
+	switch p := req.Payload.(type) {
+	case *services.AuthPayload:
+		return errAlreadyAuthenticated
+
+	case *services.SongPayload:
+		switch req.Type {
+		case "addToQueue":
+			song, idx, err := hub.AddSong(roomID, client, p.Song)
+			if err != nil {
+				var rejection *services.QueueRejection
+				if errors.As(err, &rejection) {
+					return rejection
+				}
+				return rejected("add the song")
+			}
+			hub.Broadcast(roomID, services.Event{
+				Type:  "addToQueue",
+				Song:  &song,
+				Index: &idx,
+			})
+
+		case "playNext":
+			queue, idx, err := hub.PlayNext(roomID, client, p.Song)
+			if err != nil {
+				var rejection *services.QueueRejection
+				if errors.As(err, &rejection) {
+					return rejection
+				}
+				return rejected("queue the song")
+			}
+			hub.BroadcastQueue(roomID, queue, idx)
+		}
+
+	case *services.PlayPayload:
+		var song services.Song
+		if p.Song != nil {
+			song = *p.Song
+		}
+		pb, song, ok := hub.Play(roomID, song, p.PositionMs)
+		if !ok {
+			return rejected("play")
+		}
+		event := services.Event{
+			Type:       "play",
+			Playback:   &pb,
+			ServerTime: pb.UpdatedAt,
+		}
+		if p.Song != nil {
+			event.Song = &song
+		}
+		hub.Broadcast(roomID, event)
+
+	case *services.PausePayload:
+		pb, ok := hub.Pause(roomID, p.PositionMs)
+		if !ok {
+			return rejected("pause")
+		}
+		hub.Broadcast(roomID, services.Event{
+			Type:       "pause",
+			Playback:   &pb,
+			ServerTime: pb.UpdatedAt,
+		})
+
+	case *services.SeekPayload:
+		pb, ok := hub.Seek(roomID, *p.PositionMs)
+		if !ok {
+			return rejected("seek")
+		}
+		hub.Broadcast(roomID, services.Event{
+			Type:       "seek",
+			Playback:   &pb,
+			ServerTime: pb.UpdatedAt,
+		})
+
+	case *services.IndexPayload:
+		return handleIndexRequest(hub, client, req.Type, *p.Index)
+
+	case *services.MovePayload:
+		queue, idx, ok := hub.MoveInQueue(roomID, *p.Index, *p.ToIndex)
+		if !ok {
+			return rejected("move the song")
+		}
+		hub.BroadcastQueue(roomID, queue, idx)
+
+	case *services.TargetPayload:
+		return handleTargetRequest(hub, client, req.Type, p.TargetUserID)
+
+	case *services.SetRolePayload:
+		user, ok := hub.SetRole(roomID, p.TargetUserID, p.Role)
+		if !ok {
+			return rejected("change the role")
+		}
+		hub.NotifyRoleChange(roomID, user, p.Role)
+
+	case *services.SetPolicyPayload:
+		policy, ok := hub.SetPolicy(roomID, *p.Policy)
+		if !ok {
+			return rejected("change the policy")
+		}
+		hub.Broadcast(roomID, services.Event{
+			Type:   "policy_changed",
+			Policy: &policy,
+		})
+
+	case *services.ShufflePayload:
+		mode, queue, idx, ok := hub.SetShuffle(roomID, *p.Shuffle)
+		if !ok {
+			return rejected("change shuffle")
+		}
+		hub.Broadcast(roomID, services.Event{
+			Type:           "play_mode_changed",
+			Mode:           &mode,
+			Queue:          queue,
+			CurrentSongIdx: &idx,
+		})
+
+	case *services.RepeatPayload:
+		mode, ok := hub.SetRepeat(roomID, p.Repeat)
+		if !ok {
+			return rejected("change repeat")
+		}
+		hub.Broadcast(roomID, services.Event{
+			Type: "play_mode_changed",
+			Mode: &mode,
+		})
+
+	case *services.ChatPayload:
+		msg, ok := hub.PostChat(roomID, client, p.Text)
+		if !ok {
+			return rejected("send the message")
+		}
+		hub.Broadcast(roomID, services.Event{
+			Type:    "chat",
+			User:    client.User,
+			Message: &msg,
+		})
+
+	case *services.ReactionPayload:
+		hub.BroadcastReaction(roomID, client.User, p.Emoji)
+
+	case *services.DeleteMessagePayload:
+		if !hub.DeleteChat(roomID, p.MessageID) {
+			return rejected("delete the message")
+		}
+		hub.Broadcast(roomID, services.Event{
+			Type:      "chat_deleted",
+			MessageID: p.MessageID,
+		})
+
+	case *services.SetAccessPayload:
+		if !hub.SetAccess(roomID, p.Password, p.Knock) {
+			return rejected("change access")
+		}
+		hub.Send(client, services.Event{Type: "access_updated"})
+
+	case *services.ReplayPayload:
+		hub.CatchUp(client, *p.LastSeq)
+
+	case *services.EmptyPayload:
+		return handleBareRequest(hub, client, req.Type)
+
+	default:
+		return services.NewProtocolError(services.CodeUnknownType, "unhandled event type %q", req.Type)
+	}
+
+	return nil
+}
+
+// handleIndexRequest carries out requests that point at a queue position
+func handleIndexRequest(hub *services.Hub, client *services.Client, eventType string, index int) error {
+	roomID := client.Room
+
+	switch eventType {
+	case "removeFromQueue":
+		queue, idx, ok := hub.RemoveFromQueue(roomID, index)
+		if !ok {
+			return rejected("remove the song")
+		}
+		hub.BroadcastQueue(roomID, queue, idx)
+
+	case "jumpTo":
+		song, ok := hub.JumpTo(roomID, index)
+		if !ok {
+			return rejected("jump to the song")
+		}
+		hub.BroadcastTrackChange(roomID, "jumpTo", song)
+
+	case "upvote", "downvote", "unvote":
+		vote := map[string]int{"upvote": 1, "downvote": -1, "unvote": 0}[eventType]
+		queue, idx, ok := hub.VoteSong(roomID, client, index, vote)
+		if !ok {
+			return rejected("count the vote")
+		}
+		hub.BroadcastQueue(roomID, queue, idx)
+	}
+
+	return nil
+}
+
+// handleTargetRequest carries out host actions aimed at another user
+func handleTargetRequest(hub *services.Hub, client *services.Client, eventType, targetUserID string) error {
+	roomID := client.Room
+
+	switch eventType {
+	case "transferHost":
+		newHost, ok := hub.TransferHost(roomID, client, targetUserID)
+		if !ok {
+			return rejected("hand over the room")
+		}
+		hub.NotifyHostChange(roomID, newHost)
+
+	case "muteUser", "unmuteUser":
+		role := services.RoleMuted
+		if eventType == "unmuteUser" {
+			role = services.RoleListener
+		}
+		user, ok := hub.SetRole(roomID, targetUserID, role)
+		if !ok {
+			return rejected("change the role")
+		}
+		hub.NotifyRoleChange(roomID, user, role)
+
+	case "approveJoin", "denyJoin":
+		if !hub.AnswerKnock(roomID, targetUserID, eventType == "approveJoin") {
+			return rejected("answer the knock")
+		}
+	}
+
+	return nil
+}
+
+// handleBareRequest carries out requests that need no payload
+func handleBareRequest(hub *services.Hub, client *services.Client, eventType string) error {
+	roomID := client.Room
+
+	switch eventType {
+	case "next":
+		nextSong, ok := hub.NextSong(roomID)
+		if !ok {
+			return rejected("skip ahead")
+		}
+		hub.BroadcastTrackChange(roomID, "next", nextSong)
+
+	case "previous":
+		prevSong, ok := hub.PreviousSong(roomID)
+		if !ok {
+			return rejected("go back")
+		}
+		hub.BroadcastTrackChange(roomID, "previous", prevSong)
+
+	case "clearQueue":
+		queue, idx, ok := hub.ClearQueue(roomID)
+		if !ok {
+			return rejected("clear the queue")
+		}
+		hub.BroadcastQueue(roomID, queue, idx)
+
+	case "voteSkip":
+		tally, nextSong, skipped := hub.VoteSkip(roomID, client)
+		if skipped {
+			hub.BroadcastTrackChange(roomID, "next", nextSong)
+		} else if tally.Needed > 0 {
+			hub.BroadcastSkipTally(roomID, tally)
+		} else {
+			return rejected("count the vote")
+		}
+
+	case "sync":
+		// Any client may ask for a fresh snapshot, e.g. after a stall
+		hub.SendSync(client)
+	}
+
+	return nil
+}
+
+// admit runs the room's door policy for a freshly authenticated client,
+// knocking on the host's door if needed. It reports whether the client may
+// join; rejected clients have already been told why.
+func admit(hub *services.Hub, limiter *services.RateLimiter, roomID string, client *services.Client, id string, auth *services.AuthPayload) bool {
+	creds := services.JoinCredentials{Invite: auth.Invite}
+	if auth.Password != nil && *auth.Password != "" {
+		if ok, retryAfter := limiter.AllowPassword(roomID, client.User.ID); !ok {
+			log.Printf("User %s is guessing the password of room %s too fast", client.User.Name, roomID)
+			hub.SendError(client, id, rateLimited(retryAfter))
+			return false
+		}
+		creds.Password = *auth.Password
+	}
+
+	switch hub.CheckAccess(roomID, client.User, creds) {
+	case services.AccessAdmitted:
+		return true
+
+	case services.AccessKnock:
+		hub.Send(client, services.Event{Type: "join_pending"})
+		admitted, err := hub.Knock(roomID, client)
+		if err != nil {
+			log.Printf("Knock by %s failed: %v", client.User.Name, err)
+			hub.Send(client, services.Event{Type: "join_denied", Text: err.Error()})
+			return false
+		}
+		// A host's answer has already been delivered to the client
+		return admitted
+	}
+
+	log.Printf("User %s was refused entry to room %s", client.User.Name, roomID)
+	hub.Send(client, services.Event{Type: "access_denied"})
+	return false
+}
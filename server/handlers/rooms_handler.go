@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"Vybe/services"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CreateRoom opens a room owned by the caller and returns its join code as the ID
+func CreateRoom(hub *services.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type Request struct {
+			Name     string `json:"name"`
+			Public   bool   `json:"public"`
+			Password string `json:"password"`
+			Knock    bool   `json:"knock"`
+		}
+
+		user := CurrentUser(c)
+
+		rq := new(Request)
+		if err := c.BodyParser(rq); err != nil {
+			log.Println("Error parsing request body:", err)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request format"})
+		}
+
+		room, err := hub.CreateRoom(user, rq.Name, rq.Public, rq.Password, rq.Knock)
+		if errors.Is(err, services.ErrInvalidRoomName) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			log.Println("Error creating room:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create room"})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(room)
+	}
+}
+
+// ListRooms returns the public lobby
+func ListRooms(hub *services.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rooms, err := hub.ListPublicRooms(CurrentUser(c))
+		if err != nil {
+			log.Println("Error listing rooms:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list rooms"})
+		}
+
+		return c.JSON(rooms)
+	}
+}
+
+// GetRoom returns a room's public metadata. Locked rooms only show what's
+// playing to people who have been let in.
+func GetRoom(hub *services.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		room, err := hub.GetRoomInfo(c.Params("roomID"), CurrentUser(c))
+		if errors.Is(err, services.ErrRoomNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Room not found"})
+		}
+		if err != nil {
+			log.Println("Error fetching room:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch room"})
+		}
+
+		return c.JSON(room)
+	}
+}
+
+// GetQueue lists a room's queue, including who added each song and when.
+// Locked rooms only show it to people who have been let in.
+func GetQueue(hub *services.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		queue, err := hub.GetQueue(c.Params("roomID"), CurrentUser(c))
+		if errors.Is(err, services.ErrRoomNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Room not found"})
+		}
+		if errors.Is(err, services.ErrRoomPrivate) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Join the room to see its queue"})
+		}
+		if err != nil {
+			log.Println("Error fetching queue:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch queue"})
+		}
+
+		return c.JSON(queue)
+	}
+}
+
+// GetHistory lists what a room has played. ?format=csv or ?format=m3u
+// downloads it instead, ready to import as a playlist. Locked rooms only
+// show it to people who have been let in.
+func GetHistory(hub *services.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roomID := c.Params("roomID")
+		history, err := hub.GetHistory(roomID, CurrentUser(c))
+		if errors.Is(err, services.ErrRoomNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Room not found"})
+		}
+		if errors.Is(err, services.ErrRoomPrivate) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Join the room to see its history"})
+		}
+		if err != nil {
+			log.Println("Error fetching history:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch history"})
+		}
+
+		switch c.Query("format", "json") {
+		case "json":
+			return c.JSON(history)
+		case "csv":
+			c.Attachment(roomID + "-history.csv")
+			return services.WriteHistoryCSV(c, history)
+		case "m3u":
+			c.Attachment(roomID + "-history.m3u")
+			c.Set(fiber.HeaderContentType, "audio/x-mpegurl")
+			return services.WriteHistoryM3U(c, history)
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be json, csv or m3u"})
+		}
+	}
+}
+
+// CloseRoom ends a room; only its host may do this
+func CloseRoom(hub *services.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := CurrentUser(c)
+
+		err := hub.CloseRoom(c.Params("roomID"), user)
+		switch {
+		case errors.Is(err, services.ErrRoomNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Room not found"})
+		case errors.Is(err, services.ErrNotRoomHost):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the host can close the room"})
+		case err != nil:
+			log.Println("Error closing room:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to close room"})
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// CreateInvite mints a signed, time-limited invite token for a room
+func CreateInvite(hub *services.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type Request struct {
+			TTLMinutes int `json:"ttlMinutes"`
+		}
+
+		user := CurrentUser(c)
+
+		rq := new(Request)
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(rq); err != nil {
+				log.Println("Error parsing request body:", err)
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request format"})
+			}
+		}
+
+		token, expiresAt, err := hub.CreateInvite(c.Params("roomID"), user, time.Duration(rq.TTLMinutes)*time.Minute)
+		switch {
+		case errors.Is(err, services.ErrRoomNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Room not found"})
+		case errors.Is(err, services.ErrNotRoomHost):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the host can create invites"})
+		case errors.Is(err, services.ErrInvitesDisabled):
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Invites are not enabled on this server"})
+		case err != nil:
+			log.Println("Error creating invite:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create invite"})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"token":     token,
+			"expiresAt": expiresAt,
+		})
+	}
+}
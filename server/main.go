@@ -4,14 +4,12 @@ import (
 	"Vybe/handlers"
 	"Vybe/services"
 	"Vybe/utils"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -31,7 +29,26 @@ func main() {
 		},
 	}))
 
-	hub := services.NewHub()
+	// Rooms live in Redis so instances can share them. ROOM_STORE=memory
+	// keeps them in this process instead, for a single instance without Redis.
+	var roomStore services.RoomStore = services.NewRedisRoomStore(utils.RedisClient)
+	var broker services.Broker = services.NewRedisBroker(utils.RedisClient)
+	if os.Getenv("ROOM_STORE") == "memory" {
+		log.Println("Keeping rooms in memory; they won't survive a restart or be shared between instances")
+		roomStore = services.NewMemoryRoomStore()
+		broker = services.NewLocalBroker()
+	}
+	hub, err := services.NewHub(roomStore, broker)
+	if err != nil {
+		log.Fatalf("Error starting room hub: %v", err)
+	}
+
+	// Limits are per instance unless RATE_LIMIT_STORE=redis
+	var rateLimitStore services.RateLimitStore = services.NewMemoryRateLimitStore()
+	if os.Getenv("RATE_LIMIT_STORE") == "redis" {
+		rateLimitStore = services.NewRedisRateLimitStore(utils.RedisClient)
+	}
+	limiter := services.NewRateLimiter(rateLimitStore, services.LoadRateLimits())
 
 	var PORT string = os.Getenv("PORT")
 
@@ -43,132 +60,48 @@ func main() {
 		AllowCredentials: true,
 	}))
 
-	app.Get("/", func(c *fiber.Ctx) error {
+	// Everything needs a signed-in user except the routes allow-listed here.
+	// Sockets authenticate in-band and the Spotify routes carry Spotify's
+	// own tokens. Streams are fetched by <audio src>, which can't send a
+	// header, so only they take the token in the URL.
+	api := app.Group("/", handlers.Auth(
+		[]string{
+			"GET /",
+			"GET /ws/*",
+			"GET /rooms",
+			"GET /rooms/*",
+			"GET /spotify/*",
+		},
+		[]string{"GET /stream/*", "HEAD /stream/*"},
+	))
+
+	api.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("Server Running 🚀")
 	})
 
-	app.Get("/ws/:roomID/:role", websocket.New(func(c *websocket.Conn) {
-		roomID := c.Params("roomID")
-		role := c.Params("role")
+	api.Get("/ws/:roomID/:role", handlers.ValidRoomID, handlers.RoomSocket(hub, limiter))
 
-		client := &services.Client{
-			Conn:   c,
-			Room:   roomID,
-			IsHost: role == "host",
-			User:   nil,
-		}
-
-		hub.JoinRoom(roomID, client)
-		defer func() {
-			// Notify other users that someone left before removing from room
-			if client.User != nil {
-				hub.NotifyUserLeave(roomID, client.User)
-			}
-			hub.LeaveRoom(roomID, client)
-		}()
-		log.Printf("User %s joined room %s", client.Conn.RemoteAddr(), roomID)
-
-		authenticated := false
-		for {
-			_, msg, err := c.ReadMessage()
-			if err != nil {
-				log.Printf("Error reading message: %v", err)
-				break
-			}
-
-			var event services.Event
-			if err := json.Unmarshal(msg, &event); err != nil {
-				log.Printf("Invalid event payload: %v", err)
-				continue
-			}
-
-			if !authenticated {
-				if event.Type == "auth" && event.Token != "" {
-					user, err := services.ParseJWT(event.Token)
-					if err != nil {
-						log.Printf("Auth failed : %v", err)
-						c.WriteMessage(websocket.TextMessage, []byte("unauthorized"))
-						c.Close()
-						return
-					}
-
-					client.User = user
-					log.Printf("User %s authenticated", user.Name)
-
-					c.WriteJSON(fiber.Map{
-						"type":   "auth_success",
-						"user":   user,
-						"isHost": client.IsHost,
-					})
-
-					// Notify other users that someone joined
-					hub.NotifyUserJoin(roomID, user)
-					// Send current user list to the newly joined user
-					hub.BroadcastUsers(roomID)
-					authenticated = true
-					continue
-				} else {
-					log.Printf("Unauthenticated message: %v", event)
-					continue
-				}
-			}
-
-			switch event.Type {
-			case "addToQueue":
-				if event.Song.VideoID != "" {
-					hub.AddSong(roomID, event.Song) 
-					hub.Broadcast(roomID, event)
-				}
-
-			case "next":
-				if client.IsHost {
-					if nextSong, ok := hub.NextSong(roomID); ok {
-						hub.Broadcast(roomID, services.Event{
-							Type: "next",
-							Song: nextSong,
-						})
-					}
-				}
-
-			case "previous":
-				if client.IsHost {
-					if prevSong, ok := hub.PreviousSong(roomID); ok {
-						hub.Broadcast(roomID, services.Event{
-							Type: "previous",
-							Song: prevSong,
-						})
-					}
-				}
-
-			case "play":
-				if client.IsHost {
-					hub.Broadcast(roomID, event)
-				}
-			case "pause":
-				if client.IsHost {
-					hub.Broadcast(roomID, event)
-				}
-
-			default:
-				// optional: allow listeners to send other events like "ready", "chat", etc.
-				log.Printf("Unknown or unauthorized event: %+v", event)
-			}
-		}
-	}))
+	api.Post("/rooms", handlers.RateLimit(limiter, "createRoom"), handlers.CreateRoom(hub))
+	api.Get("/rooms", handlers.RateLimit(limiter, "listRooms"), handlers.ListRooms(hub))
+	api.Get("/rooms/:roomID", handlers.ValidRoomID, handlers.GetRoom(hub))
+	api.Get("/rooms/:roomID/queue", handlers.ValidRoomID, handlers.GetQueue(hub))
+	api.Get("/rooms/:roomID/history", handlers.ValidRoomID, handlers.GetHistory(hub))
+	api.Delete("/rooms/:roomID", handlers.ValidRoomID, handlers.CloseRoom(hub))
+	api.Post("/rooms/:roomID/invites", handlers.ValidRoomID, handlers.CreateInvite(hub))
 
-	app.Get("/me", handlers.GetUserData)
+	api.Get("/me", handlers.GetUserData)
 
-	app.Get("/spotify/playlists", handlers.GetAllPlaylists)
-	app.Get("/spotify/playlist/:PID", handlers.GetPlaylistTracks)
-	app.Get("/spotify/public/playlist/:PID", handlers.GetPlaylistTracksPublic)
+	api.Get("/spotify/playlists", handlers.GetAllPlaylists)
+	api.Get("/spotify/playlist/:PID", handlers.GetPlaylistTracks)
+	api.Get("/spotify/public/playlist/:PID", handlers.GetPlaylistTracksPublic)
 
-	app.Post("/search", handlers.SingleSearch)
-	// app.Post("/playlist/tracks/search", handlers.PlaylistTracksSearch)
-	app.Post("/youtube/search", handlers.ApiSearch)
-	app.Post("/youtube/basic-search", handlers.BasicApiSearch)
+	api.Post("/search", handlers.RateLimit(limiter, "search"), handlers.SingleSearch)
+	// api.Post("/playlist/tracks/search", handlers.PlaylistTracksSearch)
+	api.Post("/youtube/search", handlers.RateLimit(limiter, "youtubeSearch"), handlers.ApiSearch)
+	api.Post("/youtube/basic-search", handlers.RateLimit(limiter, "youtubeSearch"), handlers.BasicApiSearch)
 
-	app.Post("/transify", handlers.Transify)
-	app.Get("/stream/:videoID", handlers.StreamAudio)
+	api.Post("/transify", handlers.RateLimit(limiter, "transify"), handlers.Transify)
+	api.Get("/stream/:videoID", handlers.RateLimit(limiter, "stream"), handlers.StreamAudio)
 
 	// Run the server in a goroutine so we can catch shutdown signals
 	go func() {
@@ -184,6 +117,11 @@ func main() {
 
 	log.Println("Shutting down server...")
 
+	// Stop cross-instance fan-out before Redis goes away
+	if err := hub.Close(); err != nil {
+		log.Printf("Error closing hub: %v", err)
+	}
+
 	// Close Redis client
 	if err := utils.RedisClient.Close(); err != nil {
 		log.Printf("Error closing Redis: %v", err)
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// knockTimeout is how long a knocking client waits for the host
+	knockTimeout = 2 * time.Minute
+	// Invite tokens default to a day and can't outlive a week
+	defaultInviteTTL = 24 * time.Hour
+	maxInviteTTL     = 7 * 24 * time.Hour
+	// passwordIterations is the PBKDF2-SHA256 work factor for room passwords
+	passwordIterations = 600_000
+	passwordKeyLength  = 32
+)
+
+var (
+	ErrNoHostToAnswer = errors.New("the host is not in the room to let you in")
+	ErrKnockTimedOut  = errors.New("the host did not answer in time")
+	errKnockAbandoned = errors.New("the client went away while knocking")
+	// ErrInvitesDisabled means INVITE_SECRET isn't set
+	ErrInvitesDisabled = errors.New("invites are disabled: INVITE_SECRET is not set")
+)
+
+// RoomAccess controls who may join. An empty password and Knock off means
+// anyone with the room ID can come in, which is how rooms used to work.
+type RoomAccess struct {
+	PasswordHash string `json:"passwordHash,omitempty"`
+	PasswordSalt string `json:"passwordSalt,omitempty"`
+	Knock        bool   `json:"knock"`
+	// Admitted holds user IDs the host has let in, so they skip the knock
+	// when they reconnect
+	Admitted []string `json:"admitted,omitempty"`
+}
+
+// Admission is the outcome of checking someone at the door
+type Admission int
+
+const (
+	AccessAdmitted Admission = iota
+	AccessKnock
+	AccessRejected
+)
+
+// JoinCredentials is what a client presents alongside its auth token
+type JoinCredentials struct {
+	Password string
+	Invite   string
+}
+
+func hashPassword(password, salt string) string {
+	key, err := pbkdf2.Key(sha256.New, password, []byte(salt), passwordIterations, passwordKeyLength)
+	if err != nil {
+		// Only possible for out-of-range parameters, which are constants
+		panic(err)
+	}
+	return hex.EncodeToString(key)
+}
+
+// roomPassword is a salted, hashed room password. Hashing is slow on
+// purpose, so it is done before a store update rather than inside one.
+type roomPassword struct {
+	hash, salt string
+}
+
+// newRoomPassword hashes password with a fresh salt; an empty password
+// gives the zero roomPassword, which means none
+func newRoomPassword(password string) roomPassword {
+	if password == "" {
+		return roomPassword{}
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	p := roomPassword{salt: hex.EncodeToString(salt)}
+	p.hash = hashPassword(password, p.salt)
+	return p
+}
+
+func (a *RoomAccess) setPassword(p roomPassword) {
+	a.PasswordHash, a.PasswordSalt = p.hash, p.salt
+}
+
+func (a RoomAccess) checkPassword(password string) bool {
+	if a.PasswordHash == "" || password == "" {
+		return false
+	}
+	got := hashPassword(password, a.PasswordSalt)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(a.PasswordHash)) == 1
+}
+
+// ---- Invite tokens ----
+
+// inviteSecret is the key invites are signed with. It is deliberately not
+// JWT_SECRET, which may be unset now that user tokens can be checked against
+// a JWKS, and an empty HMAC key would let anyone forge an invite.
+func inviteSecret() ([]byte, error) {
+	secret := os.Getenv("INVITE_SECRET")
+	if secret == "" {
+		return nil, ErrInvitesDisabled
+	}
+	return []byte(secret), nil
+}
+
+// CreateInvite signs a time-limited token that lets its holder into the room
+// without a password or knock. Only the host may mint them.
+func (h *Hub) CreateInvite(roomID string, by *User, ttl time.Duration) (token string, expiresAt time.Time, err error) {
+	secret, err := inviteSecret()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	state, err := h.loadState(roomID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !state.inUse() {
+		return "", time.Time{}, ErrRoomNotFound
+	}
+	if state.HostID != by.ID {
+		return "", time.Time{}, ErrNotRoomHost
+	}
+
+	if ttl <= 0 {
+		ttl = defaultInviteTTL
+	}
+	expiresAt = time.Now().Add(min(ttl, maxInviteTTL))
+
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":  "invite",
+		"room": roomID,
+		"by":   by.ID,
+		"exp":  expiresAt.Unix(),
+	}).SignedString(secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+func verifyInvite(roomID, tokenString string) error {
+	secret, err := inviteSecret()
+	if err != nil {
+		return err
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return secret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return fmt.Errorf("invalid or expired invite: %w", err)
+	}
+
+	if claims["typ"] != "invite" || claims["room"] != roomID {
+		return errors.New("invite is for a different room")
+	}
+	return nil
+}
+
+// ---- Admission ----
+
+// CheckAccess decides whether a user may join: the host, the creator and
+// anyone already let in always can, then a valid invite or the right
+// password; otherwise they knock if the room allows it, or are turned away
+// if it has a password.
+func (h *Hub) CheckAccess(roomID string, user *User, creds JoinCredentials) Admission {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
+		return AccessRejected
+	}
+
+	access := state.Access
+	switch {
+	case state.HostID == user.ID:
+		return AccessAdmitted
+	case state.Meta.CreatedBy != nil && state.Meta.CreatedBy.ID == user.ID:
+		return AccessAdmitted
+	case slices.Contains(access.Admitted, user.ID):
+		return AccessAdmitted
+	case creds.Invite != "" && verifyInvite(roomID, creds.Invite) == nil:
+		return AccessAdmitted
+	case access.checkPassword(creds.Password):
+		return AccessAdmitted
+	case access.Knock:
+		return AccessKnock
+	case access.PasswordHash != "":
+		return AccessRejected
+	}
+	return AccessAdmitted
+}
+
+// Knock asks the host to let a client in and blocks until they answer, the
+// client is stopped (its connection died), or knockTimeout passes. The client is not in the room
+// (and gets no broadcasts) while it waits.
+func (h *Hub) Knock(roomID string, c *Client) (bool, error) {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		return false, err
+	}
+	if state.hostMember() == nil {
+		return false, ErrNoHostToAnswer
+	}
+
+	answer := make(chan bool, 1)
+	h.mux.Lock()
+	room, ok := h.rooms[roomID]
+	if !ok {
+		room = newRoom()
+		h.rooms[roomID] = room
+	}
+	room.pending[c] = answer
+	h.mux.Unlock()
+
+	defer func() {
+		h.mux.Lock()
+		delete(room.pending, c)
+		if len(room.clients) == 0 && len(room.pending) == 0 && h.rooms[roomID] == room {
+			delete(h.rooms, roomID)
+		}
+		h.mux.Unlock()
+	}()
+
+	h.SendToUser(roomID, state.HostID, Event{
+		Type: "join_request",
+		User: c.User,
+	})
+
+	select {
+	case admitted := <-answer:
+		// Don't let a client in that died just as the host answered
+		select {
+		case <-c.Done():
+			return false, errKnockAbandoned
+		default:
+		}
+		return admitted, nil
+	case <-c.Done():
+		return false, errKnockAbandoned
+	case <-time.After(knockTimeout):
+		return false, ErrKnockTimedOut
+	}
+}
+
+// AnswerKnock lets a knocking user in (remembering them for next time) or
+// turns them away. Their connection may be on any instance.
+func (h *Hub) AnswerKnock(roomID, userID string, admit bool) bool {
+	if admit {
+		_, err := h.updateState(roomID, func(state *RoomState) error {
+			if !slices.Contains(state.Access.Admitted, userID) {
+				state.Access.Admitted = append(state.Access.Admitted, userID)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to admit user in room %s: %v", roomID, err)
+			return false
+		}
+	}
+
+	eventType := "join_denied"
+	if admit {
+		eventType = "join_approved"
+	}
+	h.publish(roomID, Event{Type: eventType}, busMessage{To: userID, Admit: &admit})
+	return true
+}
+
+// notifyPending passes a targeted message on to matching knocking clients.
+// Caller must hold the hub lock.
+func (r *Room) notifyPending(msg busMessage, f *frame) {
+	for client, answer := range r.pending {
+		if client.User == nil || client.User.ID != msg.To {
+			continue
+		}
+		client.sendFrame(f)
+		if msg.Admit != nil {
+			select {
+			case answer <- *msg.Admit:
+			default:
+			}
+		}
+	}
+}
+
+// SetAccess updates the room's password and/or knock mode. A nil field is
+// left as is; an empty password removes it.
+func (h *Hub) SetAccess(roomID string, password *string, knock *bool) bool {
+	var hashed roomPassword
+	if password != nil {
+		hashed = newRoomPassword(*password)
+	}
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		if password != nil {
+			state.Access.setPassword(hashed)
+		}
+		if knock != nil {
+			state.Access.Knock = *knock
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to set access in room %s: %v", roomID, err)
+		return false
+	}
+
+	return true
+}
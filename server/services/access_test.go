@@ -0,0 +1,25 @@
+package services
+
+import "testing"
+
+func TestRoomPassword(t *testing.T) {
+	var access RoomAccess
+	access.setPassword(newRoomPassword("hunter2"))
+
+	if !access.checkPassword("hunter2") {
+		t.Error("right password rejected")
+	}
+	for _, wrong := range []string{"", "hunter3", "Hunter2"} {
+		if access.checkPassword(wrong) {
+			t.Errorf("wrong password %q accepted", wrong)
+		}
+	}
+
+	access.setPassword(newRoomPassword(""))
+	if access.PasswordHash != "" || access.PasswordSalt != "" {
+		t.Errorf("empty password left hash %q salt %q", access.PasswordHash, access.PasswordSalt)
+	}
+	if access.checkPassword("") {
+		t.Error("room without a password accepted an empty one")
+	}
+}
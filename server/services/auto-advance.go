@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Auto-advance moves a room on when the playing song ends, so the party
+// doesn't stall when the host's tab sleeps. Every state write reschedules the
+// room's timer; when it fires, the song's end is checked against the stored
+// state again, so stale timers and timers on several instances are harmless.
+
+const (
+	// maxCrossfade and maxGap bound RoomPolicy.GapMs either side of zero
+	maxCrossfade = 12 * time.Second
+	maxGap       = 10 * time.Second
+)
+
+var (
+	errNotDue       = errors.New("song has not ended yet")
+	errNoListeners  = errors.New("nobody is listening")
+	errNotAdvancing = errors.New("room is not auto-advancing")
+)
+
+// advanceDue reports how long until the playing song should give way to the
+// next. It is false when nothing is playing, the song's length isn't known,
+// or the host advances by hand.
+func (s *RoomState) advanceDue(now int64) (time.Duration, bool) {
+	if s.Policy.ManualAdvance || !s.Playback.Playing {
+		return 0, false
+	}
+	song, ok := s.currentSong()
+	if !ok || song.VideoID != s.Playback.VideoID {
+		return 0, false
+	}
+	d, ok := parseTrackDuration(song.Duration)
+	if !ok {
+		return 0, false
+	}
+
+	end := d + time.Duration(s.Policy.GapMs)*time.Millisecond
+	played := time.Duration(s.Playback.positionAt(now)) * time.Millisecond
+	return max(end-played, 0), true
+}
+
+// scheduleAdvance (re)arms the room's timer for state, or stops it when the
+// room isn't playing anything it can time
+func (h *Hub) scheduleAdvance(roomID string, state *RoomState) {
+	due, ok := state.advanceDue(nowMs())
+
+	h.timersMux.Lock()
+	defer h.timersMux.Unlock()
+
+	if timer, exists := h.advanceTimers[roomID]; exists {
+		timer.Stop()
+		delete(h.advanceTimers, roomID)
+	}
+	if !ok {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(due, func() {
+		// A fired timer is done with; rooms that don't advance (closed,
+		// empty) would otherwise keep their entry forever
+		h.timersMux.Lock()
+		if h.advanceTimers[roomID] == timer {
+			delete(h.advanceTimers, roomID)
+		}
+		h.timersMux.Unlock()
+
+		h.autoAdvance(roomID)
+	})
+	h.advanceTimers[roomID] = timer
+}
+
+func (h *Hub) stopAdvanceTimers() {
+	h.timersMux.Lock()
+	defer h.timersMux.Unlock()
+
+	for roomID, timer := range h.advanceTimers {
+		timer.Stop()
+		delete(h.advanceTimers, roomID)
+	}
+}
+
+// autoAdvance moves to the next song if the current one has really ended. At
+// the end of the queue playback stops instead.
+func (h *Hub) autoAdvance(roomID string) {
+	var (
+		next  Song
+		ended bool
+	)
+	state, err := h.updateState(roomID, func(state *RoomState) error {
+		now := nowMs()
+		due, ok := state.advanceDue(now)
+		switch {
+		case !ok:
+			return errNotAdvancing
+		case due > 0:
+			return errNotDue
+		case len(state.Members) == 0:
+			return errNoListeners
+		}
+
+		song, err := state.advance(true)
+		if errors.Is(err, errEndOfQueue) {
+			state.endPlay(now, PlayCompleted)
+			state.Playback.PositionMs = state.Playback.positionAt(now)
+			state.Playback.Playing = false
+			state.Playback.UpdatedAt = now
+			ended = true
+			return nil
+		}
+		next = song
+		return err
+	})
+	switch {
+	case errors.Is(err, errNotDue):
+		// Someone seeked or the clock moved under us; try again later
+		if state, err := h.loadState(roomID); err == nil {
+			h.scheduleAdvance(roomID, state)
+		}
+		return
+	case errors.Is(err, errNotAdvancing), errors.Is(err, errNoListeners):
+		return
+	case err != nil:
+		log.Printf("Failed to auto-advance room %s: %v", roomID, err)
+		return
+	}
+
+	if ended {
+		pb := state.Playback
+		h.Broadcast(roomID, Event{
+			Type:       "pause",
+			Playback:   &pb,
+			ServerTime: pb.UpdatedAt,
+		})
+		return
+	}
+
+	h.BroadcastTrackChange(roomID, "next", next)
+	h.autoplay(roomID)
+}
+
+// BroadcastTrackChange announces a queue move along with the restarted clock
+func (h *Hub) BroadcastTrackChange(roomID, eventType string, song Song) {
+	event := Event{
+		Type: eventType,
+		Song: &song,
+	}
+	if pb, ok := h.Playback(roomID); ok {
+		event.Playback = &pb
+		event.ServerTime = pb.UpdatedAt
+	}
+	h.Broadcast(roomID, event)
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdvanceTimerForgottenWhenRoomDoesNotAdvance(t *testing.T) {
+	hub := newTestHub(t)
+	// A song that has already played out, in a room nobody is in
+	state, err := hub.store.Update(context.Background(), "room", func(s *RoomState) error {
+		s.SongsQueue = []Song{{Title: "A", VideoID: "a", Duration: "0:01"}}
+		s.CurrentSongIdx = 0
+		s.Playback = PlaybackState{VideoID: "a", Playing: true, UpdatedAt: nowMs() - 5000}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.scheduleAdvance("room", state)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		hub.timersMux.Lock()
+		_, pending := hub.advanceTimers["room"]
+		hub.timersMux.Unlock()
+		if !pending {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fired timer still in advanceTimers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"slices"
+)
+
+// Autoplay keeps a room going once its queue runs dry: when the last queued
+// song starts (or a skip finds nothing after it) and the room's policy has
+// Autoplay on, the hub asks its Recommender for related tracks and appends
+// them as auto-added songs.
+
+const (
+	// autoplaySeeds is how many recently played songs recommendations are
+	// based on
+	autoplaySeeds = 3
+	// autoplayBatch is how many songs are added each time the queue runs out
+	autoplayBatch = 3
+)
+
+var errQueueNotEmpty = errors.New("queue still has songs waiting")
+
+// Recommender suggests songs to follow seeds, most recently played last.
+// Suggestions may repeat the seeds or each other; the hub filters them.
+type Recommender interface {
+	Recommend(seeds []Song) ([]Song, error)
+}
+
+// SearchRecommender recommends through the yt-music-api search, looking up
+// each seed's artist (or title, when the artist isn't known)
+type SearchRecommender struct{}
+
+func (SearchRecommender) Recommend(seeds []Song) ([]Song, error) {
+	var songs []Song
+	var lastErr error
+	for i := len(seeds) - 1; i >= 0; i-- {
+		query := seeds[i].Title
+		if len(seeds[i].Artists) > 0 {
+			query = seeds[i].Artists[0]
+		}
+		results, err := Search(query)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, result := range results {
+			songs = append(songs, SongFromYT(result))
+		}
+	}
+	if len(songs) == 0 {
+		return nil, lastErr
+	}
+	return songs, nil
+}
+
+// SetRecommender replaces the recommender autoplay uses; the default is
+// SearchRecommender
+func (h *Hub) SetRecommender(r Recommender) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.recommender = r
+}
+
+// upcoming returns the songs waiting after the one playing
+func (s *RoomState) upcoming() []Song {
+	return s.SongsQueue[s.CurrentSongIdx+1:]
+}
+
+// wantsAutoplay reports whether the room has autoplay on and has run out of
+// songs; a repeating queue never runs out
+func (s *RoomState) wantsAutoplay() bool {
+	return s.Policy.Autoplay && s.Mode.repeat() == RepeatOff && len(s.upcoming()) == 0
+}
+
+// recentSongs returns up to n songs up to and including the one playing
+func (s *RoomState) recentSongs(n int) []Song {
+	if s.CurrentSongIdx < 0 {
+		return nil
+	}
+	return s.SongsQueue[max(0, s.CurrentSongIdx+1-n) : s.CurrentSongIdx+1]
+}
+
+// autoplay tops the queue up in the background when the room wants it and
+// nothing is waiting. Only one top-up per room runs at a time here; rooms
+// shared with other instances are guarded by re-checking the queue before
+// appending.
+func (h *Hub) autoplay(roomID string) {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
+		return
+	}
+	if !state.wantsAutoplay() {
+		return
+	}
+	seeds := slices.Clone(state.recentSongs(autoplaySeeds))
+	if len(seeds) == 0 {
+		return
+	}
+
+	h.mux.Lock()
+	if h.autoplaying[roomID] {
+		h.mux.Unlock()
+		return
+	}
+	h.autoplaying[roomID] = true
+	recommender := h.recommender
+	h.mux.Unlock()
+
+	go func() {
+		defer func() {
+			h.mux.Lock()
+			delete(h.autoplaying, roomID)
+			h.mux.Unlock()
+		}()
+
+		candidates, err := recommender.Recommend(seeds)
+		if err != nil {
+			log.Printf("Autoplay for room %s failed: %v", roomID, err)
+			return
+		}
+
+		added, err := h.addAutoplaySongs(roomID, candidates)
+		if err != nil {
+			if !errors.Is(err, errQueueNotEmpty) {
+				log.Printf("Failed to add autoplay songs to room %s: %v", roomID, err)
+			}
+			return
+		}
+		for i := range added {
+			h.Broadcast(roomID, Event{Type: "addToQueue", Song: &added[i]})
+		}
+	}()
+}
+
+// addAutoplaySongs appends up to autoplayBatch of the candidates that the
+// queue policy allows and that aren't queued already
+func (h *Hub) addAutoplaySongs(roomID string, candidates []Song) (added []Song, err error) {
+	_, err = h.updateState(roomID, func(state *RoomState) error {
+		added = nil
+		if !state.wantsAutoplay() {
+			return errQueueNotEmpty
+		}
+
+		for _, song := range candidates {
+			if len(added) == autoplayBatch {
+				break
+			}
+			if song.VideoID == "" || validateSong(song) != nil {
+				continue
+			}
+			queued := slices.ContainsFunc(state.SongsQueue, func(s Song) bool {
+				return s.VideoID == song.VideoID
+			})
+			if queued || state.checkQueuePolicy(song, "") != nil {
+				continue
+			}
+
+			state.stampQueued(&song, nil)
+			song.AutoAdded = true
+			state.SongsQueue = append(state.SongsQueue, song)
+			added = append(added, song)
+		}
+		if state.CurrentSongIdx == -1 && len(state.SongsQueue) > 0 {
+			state.CurrentSongIdx = 0
+		}
+		return nil
+	})
+	return added, err
+}
@@ -4,6 +4,7 @@ import (
 	// "Vybe/utils"
 	"Vybe/utils"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -17,6 +18,16 @@ func acquireSlot() {
 	ytDlpLimit <- struct{}{}
 }
 
+// acquireSlotContext is acquireSlot that gives up when ctx is done
+func acquireSlotContext(ctx context.Context) error {
+	select {
+	case ytDlpLimit <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func releaseSlot() {
 	<-ytDlpLimit
 }
@@ -160,5 +171,6 @@ func Search(query string) ([]YTSong, error) {
 		return nil, fmt.Errorf("error parsing yt-music-api output: %v\nraw output: %s", err, out.String())
 	}
 
+	rememberDurations(results)
 	return results, nil
 }
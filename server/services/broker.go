@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker fans encoded room events out to every server instance. Each
+// instance subscribes once and delivers what it receives to its own clients.
+type Broker interface {
+	Publish(ctx context.Context, roomID string, data []byte) error
+	Subscribe(deliver func(roomID string, data []byte)) error
+	Close() error
+}
+
+// ---- In-process broker ----
+
+// LocalBroker delivers straight back to the publishing process. It is the
+// single-instance setup, no Redis required.
+type LocalBroker struct {
+	deliver func(roomID string, data []byte)
+	mux     sync.RWMutex
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+func (b *LocalBroker) Publish(ctx context.Context, roomID string, data []byte) error {
+	b.mux.RLock()
+	deliver := b.deliver
+	b.mux.RUnlock()
+
+	if deliver != nil {
+		deliver(roomID, data)
+	}
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(deliver func(roomID string, data []byte)) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.deliver = deliver
+	return nil
+}
+
+func (b *LocalBroker) Close() error { return nil }
+
+// ---- Redis pub/sub broker ----
+
+const roomEventsPrefix = "vybe:room-events:"
+
+// RedisBroker publishes every room event on "vybe:room-events:<roomID>" and
+// pattern-subscribes to all of them, so a host on one node reaches listeners
+// connected to any other.
+type RedisBroker struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	cancel context.CancelFunc
+}
+
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{client: client}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, roomID string, data []byte) error {
+	return b.client.Publish(ctx, roomEventsPrefix+roomID, data).Err()
+}
+
+func (b *RedisBroker) Subscribe(deliver func(roomID string, data []byte)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pubsub := b.client.PSubscribe(ctx, roomEventsPrefix+"*")
+	// Wait for the subscription to be confirmed so no early publish is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		cancel()
+		pubsub.Close()
+		return fmt.Errorf("redis subscribe failed: %w", err)
+	}
+
+	b.pubsub = pubsub
+	b.cancel = cancel
+
+	go func() {
+		// Channel() transparently reconnects; it is closed by pubsub.Close()
+		for msg := range pubsub.Channel() {
+			roomID := strings.TrimPrefix(msg.Channel, roomEventsPrefix)
+			deliver(roomID, []byte(msg.Payload))
+		}
+		log.Println("Redis broker subscription closed")
+	}()
+
+	return nil
+}
+
+func (b *RedisBroker) Close() error {
+	if b.pubsub == nil {
+		return nil
+	}
+	b.cancel()
+	return b.pubsub.Close()
+}
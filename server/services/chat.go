@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxChatLength     = 500
+	maxReactionLength = 16
+	// chatHistorySize is how many messages a room keeps for late joiners
+	chatHistorySize = 50
+)
+
+var (
+	errEmptyMessage    = errors.New("message is empty")
+	errMessageTooLong  = errors.New("message is too long")
+	errInvalidReaction = errors.New("invalid reaction")
+	errMessageNotFound = errors.New("message not found")
+)
+
+type ChatMessage struct {
+	ID     string `json:"id"`
+	User   *User  `json:"user"`
+	Text   string `json:"text"`
+	SentAt int64  `json:"sentAt"`
+}
+
+func validateChatText(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errEmptyMessage
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		return "", errMessageTooLong
+	}
+	return text, nil
+}
+
+func validateReaction(emoji string) error {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxReactionLength {
+		return errInvalidReaction
+	}
+	if strings.IndexFunc(emoji, unicode.IsSpace) >= 0 {
+		return errInvalidReaction
+	}
+	return nil
+}
+
+// PostChat stores a message in the room's bounded history and returns it
+func (h *Hub) PostChat(roomID string, c *Client, text string) (msg ChatMessage, status bool) {
+	text, err := validateChatText(text)
+	if err != nil {
+		log.Printf("Rejected chat from %s: %v", c.User.Name, err)
+		return ChatMessage{}, false
+	}
+
+	msg = ChatMessage{
+		ID:     uuid.NewString(),
+		User:   c.User,
+		Text:   text,
+		SentAt: nowMs(),
+	}
+
+	if err := h.appendLog(roomID, LogChat, msg, chatHistorySize); err != nil {
+		log.Printf("Failed to store chat in room %s: %v", roomID, err)
+		return ChatMessage{}, false
+	}
+
+	return msg, true
+}
+
+// DeleteChat removes a message from the room history
+func (h *Hub) DeleteChat(roomID, messageID string) bool {
+	ctx := context.Background()
+	entries, err := h.store.Entries(ctx, roomID, LogChat)
+	if err == nil {
+		err = errMessageNotFound
+		for _, entry := range entries {
+			var msg ChatMessage
+			if json.Unmarshal(entry, &msg) != nil || msg.ID != messageID {
+				continue
+			}
+			var removed bool
+			if removed, err = h.store.Remove(ctx, roomID, LogChat, entry); err == nil && !removed {
+				err = errMessageNotFound
+			}
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to delete chat in room %s: %v", roomID, err)
+		return false
+	}
+
+	return true
+}
+
+// SendChatHistory replays the room's recent messages to a single client
+func (h *Hub) SendChatHistory(c *Client) {
+	messages, err := readLog[ChatMessage](h, c.Room, LogChat)
+	if err != nil {
+		log.Printf("Failed to load chat in room %s: %v", c.Room, err)
+		return
+	}
+	h.Send(c, Event{
+		Type:     "chat_history",
+		Messages: messages,
+	})
+}
+
+// Broadcast a reaction; reactions are fire-and-forget and not kept in history
+func (h *Hub) BroadcastReaction(roomID string, user *User, emoji string) {
+	if err := validateReaction(emoji); err != nil {
+		log.Printf("Rejected reaction from %s: %v", user.Name, err)
+		return
+	}
+
+	event := Event{
+		Type:  "reaction",
+		User:  user,
+		Emoji: emoji,
+	}
+	h.Broadcast(roomID, event)
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+const (
+	// sendBufferSize is how many outbound frames a client may fall behind
+	// by before it is treated as a slow consumer and disconnected
+	sendBufferSize = 256
+	// writeWait bounds a single frame write to the network
+	writeWait = 10 * time.Second
+)
+
+// Client is one WebSocket connection. WantsHost is what the connection asked
+// for via the URL; whether it actually hosts is decided by the room state.
+//
+// Nothing writes to Conn directly: frames go through a bounded queue drained
+// by the client's own writer goroutine, so a slow listener never holds up the
+// hub and the connection only ever has one writer.
+type Client struct {
+	ID        string
+	Conn      *websocket.Conn
+	Room      string
+	WantsHost bool
+	User      *User
+	// Session is the resumable membership this connection holds
+	Session string
+	// Encoding is the wire format negotiated at the handshake
+	Encoding Encoding
+
+	send       chan []byte
+	done       chan struct{}
+	writerDone chan struct{}
+	stopOnce   sync.Once
+
+	// lastSeen is when the client last sent a frame or pong, in Unix nanos
+	lastSeen  atomic.Int64
+	leaveOnce sync.Once
+	// evicted is set when the client is dropped for falling behind
+	evicted atomic.Bool
+}
+
+func NewClient(id string, conn *websocket.Conn, roomID string, wantsHost bool, enc Encoding) *Client {
+	c := &Client{
+		ID:         id,
+		Conn:       conn,
+		Room:       roomID,
+		WantsHost:  wantsHost,
+		Encoding:   enc,
+		send:       make(chan []byte, sendBufferSize),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	c.Touch()
+	c.Conn.SetPongHandler(func(string) error {
+		c.Touch()
+		return nil
+	})
+
+	go c.writePump()
+	return c
+}
+
+// enqueue queues an encoded frame without blocking. A client whose queue is
+// full is evicted; false means the frame was dropped.
+func (c *Client) enqueue(data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return true
+	default:
+		log.Printf("Evicting slow client %s: send buffer full", c.ID)
+		c.evict()
+		return false
+	}
+}
+
+// sendFrame encodes a frame in the client's format and queues it. A frame that
+// can't be encoded is dropped without holding it against the client.
+func (c *Client) sendFrame(f *frame) bool {
+	data, err := f.encode(c.Encoding)
+	if err != nil {
+		log.Printf("Failed to encode frame for client %s: %v", c.ID, err)
+		return true
+	}
+	return c.enqueue(data)
+}
+
+// stop tells the writer to flush what is queued and hang up. It never blocks.
+func (c *Client) stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+// evict drops a client that can't keep up. Its backlog is thrown away and
+// the connection closed at once, which also ends a write stuck on it and
+// the handler's read loop.
+func (c *Client) evict() {
+	c.evicted.Store(true)
+	c.stop()
+	c.Conn.Close()
+}
+
+// Done is closed once the client has been stopped, e.g. because its
+// connection died
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close stops the client and waits for the writer to finish. The handler
+// must call this before returning, since the connection is released after.
+func (c *Client) Close() {
+	c.stop()
+	<-c.writerDone
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	defer close(c.writerDone)
+	defer c.Conn.Close()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Ping error for client %s: %v", c.ID, err)
+				c.stop()
+				return
+			}
+		case data := <-c.send:
+			if !c.write(data) {
+				c.stop()
+				return
+			}
+		case <-c.done:
+			if c.evicted.Load() {
+				return
+			}
+			// Flush whatever was queued before the stop, e.g. a final
+			// error or room_closed, then say goodbye properly
+			for {
+				select {
+				case data := <-c.send:
+					if !c.write(data) {
+						return
+					}
+				default:
+					c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+					c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) write(data []byte) bool {
+	messageType := websocket.TextMessage
+	if c.Encoding.binary() {
+		messageType = websocket.BinaryMessage
+	}
+
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.Conn.WriteMessage(messageType, data); err != nil {
+		log.Printf("Write error for client %s: %v", c.ID, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"Vybe/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Track lengths come from the server, never from whoever queued the song:
+// auto-advance and the queue's length limit act on them for the whole room.
+// Lengths seen in search results are remembered; anything else is looked up
+// with yt-dlp.
+
+const (
+	// knownDurationsSize bounds the remembered lengths; the lot is dropped
+	// when it fills up
+	knownDurationsSize    = 10000
+	durationLookupTimeout = 15 * time.Second
+)
+
+var (
+	errUnknownDuration = errors.New("couldn't find out how long the song is")
+	errNotYouTubeID    = errors.New("not a YouTube video ID")
+)
+
+// youTubeID reports whether id looks like a YouTube video ID, so nothing
+// else ends up on yt-dlp's command line
+func youTubeID(id string) bool {
+	if len(id) != 11 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// DurationResolver finds out how long a video is
+type DurationResolver interface {
+	Duration(videoID string) (time.Duration, error)
+}
+
+var knownDurations = struct {
+	lengths map[string]time.Duration
+	mux     sync.Mutex
+}{lengths: make(map[string]time.Duration)}
+
+// rememberDurations keeps the lengths of search results, which are the songs
+// most likely to be queued next
+func rememberDurations(results []YTSong) {
+	knownDurations.mux.Lock()
+	defer knownDurations.mux.Unlock()
+
+	for _, result := range results {
+		d, ok := parseTrackDuration(result.Duration)
+		if !ok || d == 0 || result.VideoID == "" {
+			continue
+		}
+		if len(knownDurations.lengths) >= knownDurationsSize {
+			clear(knownDurations.lengths)
+		}
+		knownDurations.lengths[result.VideoID] = d
+	}
+}
+
+func knownDuration(videoID string) (time.Duration, bool) {
+	knownDurations.mux.Lock()
+	defer knownDurations.mux.Unlock()
+
+	d, ok := knownDurations.lengths[videoID]
+	return d, ok
+}
+
+// YtDlpDurations answers from search results seen so far and asks yt-dlp
+// about the rest
+type YtDlpDurations struct{}
+
+func (YtDlpDurations) Duration(videoID string) (time.Duration, error) {
+	if d, ok := knownDuration(videoID); ok {
+		return d, nil
+	}
+	if !youTubeID(videoID) {
+		return 0, errNotYouTubeID
+	}
+
+	ytDlpPath := utils.YtDlpPath()
+	if ytDlpPath == "" {
+		ytDlpPath = "yt-dlp"
+	}
+	args := []string{"--skip-download", "--no-warnings", "--print", "duration"}
+	if cookies := utils.CookiesPath(); cookies != "" {
+		args = append(args, "--cookies", cookies)
+	}
+	args = append(args, "--", "https://www.youtube.com/watch?v="+videoID)
+
+	// The timeout covers waiting for a slot too: lookups run on the socket's
+	// read loop, which must get back to reading pongs
+	ctx, cancel := context.WithTimeout(context.Background(), durationLookupTimeout)
+	defer cancel()
+	if err := acquireSlotContext(ctx); err != nil {
+		return 0, fmt.Errorf("no yt-dlp slot free: %w", err)
+	}
+	defer releaseSlot()
+
+	cmd := exec.CommandContext(ctx, ytDlpPath, args...)
+
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("yt-dlp error: %v\nstderr: %s", err, stderr.String())
+	}
+
+	secs, err := strconv.ParseFloat(strings.TrimSpace(out.String()), 64)
+	if err != nil || secs <= 0 {
+		return 0, errUnknownDuration
+	}
+	d := time.Duration(secs) * time.Second
+	rememberDurations([]YTSong{{VideoID: videoID, Duration: formatTrackDuration(d)}})
+	return d, nil
+}
+
+// SetDurationResolver replaces how song lengths are found; the default is
+// YtDlpDurations
+func (h *Hub) SetDurationResolver(r DurationResolver) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.durations = r
+}
+
+// resolveDuration replaces whatever length the client sent with the real
+// one, leaving it empty (unknown) when that can't be found out
+func (h *Hub) resolveDuration(song *Song) {
+	song.Duration = ""
+	if song.VideoID == "" {
+		return
+	}
+
+	h.mux.RLock()
+	resolver := h.durations
+	h.mux.RUnlock()
+
+	d, err := resolver.Duration(song.VideoID)
+	if err != nil {
+		log.Printf("Failed to find the length of %s: %v", song.VideoID, err)
+		return
+	}
+	song.Duration = formatTrackDuration(d)
+}
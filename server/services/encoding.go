@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoding is the wire format a client asked for. Everything inside the hub
+// (the broker, the event log) stays JSON; other formats are produced on the
+// way out.
+type Encoding int
+
+const (
+	EncodingJSON Encoding = iota
+	// EncodingMsgPack sends the same envelopes as MessagePack binary frames
+	EncodingMsgPack
+)
+
+// Subprotocols are offered during the WebSocket handshake, best first.
+// Clients that can't set a subprotocol can use ?encoding=msgpack instead.
+var Subprotocols = []string{"vybe.v1.msgpack", "vybe.v1.json"}
+
+// NegotiateEncoding picks a client's encoding from the subprotocol agreed in
+// the handshake, falling back to the encoding query parameter
+func NegotiateEncoding(subprotocol, query string) Encoding {
+	switch subprotocol {
+	case "vybe.v1.msgpack":
+		return EncodingMsgPack
+	case "vybe.v1.json":
+		return EncodingJSON
+	}
+	if query == "msgpack" {
+		return EncodingMsgPack
+	}
+	return EncodingJSON
+}
+
+func (e Encoding) binary() bool {
+	return e == EncodingMsgPack
+}
+
+// frame is one outgoing message. It is encoded to each format at most once,
+// however many clients it goes to.
+type frame struct {
+	json    []byte
+	msgpack []byte
+	err     error
+	once    sync.Once
+}
+
+func newFrame(data []byte) *frame {
+	return &frame{json: data}
+}
+
+func (f *frame) encode(enc Encoding) ([]byte, error) {
+	if enc != EncodingMsgPack {
+		return f.json, nil
+	}
+	f.once.Do(func() {
+		f.msgpack, f.err = jsonToMsgpack(f.json)
+	})
+	return f.msgpack, f.err
+}
+
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(unwrapNumbers(v))
+}
+
+// unwrapNumbers turns json.Numbers back into ints or floats so they go out
+// as MessagePack numbers rather than strings
+func unwrapNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			v[k] = unwrapNumbers(x)
+		}
+	case []any:
+		for i, x := range v {
+			v[i] = unwrapNumbers(x)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// DecodeFrame decodes a client frame: binary frames are MessagePack, text
+// frames are JSON, whatever the client negotiated
+func DecodeFrame(binary bool, data []byte) (Request, error) {
+	if !binary {
+		return DecodeRequest(data)
+	}
+
+	var v any
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return Request{}, NewProtocolError(CodeBadRequest, "expected a MessagePack envelope")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Request{}, NewProtocolError(CodeBadRequest, "expected a MessagePack envelope")
+	}
+	return DecodeRequest(data)
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+)
+
+// eventLogSize is how many broadcasts a room keeps for replay. A client that
+// fell further behind than this gets a fresh snapshot instead.
+const eventLogSize = 200
+
+// LoggedEvent is a broadcast as it went out, kept so a reconnecting client can
+// be sent what it missed
+type LoggedEvent struct {
+	Seq   int64           `json:"seq"`
+	Event json.RawMessage `json:"event"`
+}
+
+// logEvent stamps a broadcast with the room's next sequence number and keeps
+// it in the room's event log. Rooms that don't exist (any more) aren't logged,
+// so a late broadcast never brings a closed room's state back.
+func (h *Hub) logEvent(roomID string, event Event) Event {
+	seq, err := h.store.NextSeq(context.Background(), roomID)
+	if err == nil {
+		event.Seq = seq
+		var data []byte
+		if data, err = encodeEvent(event); err == nil {
+			err = h.appendLog(roomID, LogEvents, LoggedEvent{Seq: seq, Event: data}, eventLogSize)
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, ErrRoomNotFound) {
+			log.Printf("Failed to log %s event in room %s: %v", event.Type, roomID, err)
+		}
+		event.Seq = 0
+	}
+	return event
+}
+
+// replay sends a client every logged event after lastSeq. It reports false
+// when the log no longer reaches back that far or has a gap.
+func (h *Hub) replay(c *Client, lastSeq int64) bool {
+	seq, err := h.store.Seq(context.Background(), c.Room)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", c.Room, err)
+		return false
+	}
+	if lastSeq >= seq {
+		return true
+	}
+	logged, err := readLog[LoggedEvent](h, c.Room, LogEvents)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", c.Room, err)
+		return false
+	}
+
+	// Instances can append out of order; sort and make sure nothing between
+	// lastSeq and seq is missing before sending any of it
+	slices.SortFunc(logged, func(a, b LoggedEvent) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	missed := logged[:0]
+	next := lastSeq + 1
+	for _, e := range logged {
+		if e.Seq < next {
+			continue
+		}
+		if e.Seq > next {
+			return false
+		}
+		missed = append(missed, e)
+		next++
+	}
+	if next <= seq {
+		return false
+	}
+
+	for _, e := range missed {
+		c.sendFrame(newFrame(e.Event))
+	}
+	return true
+}
+
+// CatchUp brings a client back up to date after lastSeq, by replaying the
+// events it missed or, if those are gone, with a full snapshot
+func (h *Hub) CatchUp(c *Client, lastSeq int64) {
+	if h.replay(c, lastSeq) {
+		return
+	}
+	h.SendRoomState(c)
+	h.SendChatHistory(c)
+	h.SendUsers(c)
+}
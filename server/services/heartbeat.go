@@ -0,0 +1,77 @@
+package services
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// pongWait is how long a connection may stay silent (no frames, no pongs)
+	// before it is considered dead
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so a live client always has a
+	// ping to answer in time
+	pingPeriod = pongWait * 9 / 10
+	// staleAfter gives the read deadline a head start before the reaper steps
+	// in, so it only catches connections whose read loop is wedged
+	staleAfter = pongWait + writeWait
+)
+
+// Touch records that the client was heard from and pushes its read deadline
+// out. The read loop calls it for every frame; pongs call it on their own.
+func (c *Client) Touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+}
+
+func (c *Client) stale(now time.Time) bool {
+	return now.Sub(time.Unix(0, c.lastSeen.Load())) > staleAfter
+}
+
+// Disconnect takes a client out of its room; left says whether the user
+// meant to go. Both the read loop and the reaper call it; only the first
+// call counts.
+func (h *Hub) Disconnect(roomID string, c *Client, left bool) {
+	c.leaveOnce.Do(func() {
+		h.LeaveRoom(roomID, c, left)
+	})
+}
+
+// runReaper drops local clients that have gone quiet for longer than
+// staleAfter, so ghosts don't linger in all_users. It also expires members
+// whose instance went away before their grace period ran out.
+func (h *Hub) runReaper() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case now := <-ticker.C:
+			for _, c := range h.staleClients(now) {
+				log.Printf("Reaping stale client %s in room %s", c.ID, c.Room)
+				c.stop()
+				h.Disconnect(c.Room, c, false)
+			}
+			for _, roomID := range h.localRooms() {
+				h.expireMembers(roomID)
+			}
+		}
+	}
+}
+
+func (h *Hub) staleClients(now time.Time) []*Client {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	var stale []*Client
+	for _, room := range h.rooms {
+		for client := range room.clients {
+			if client.stale(now) {
+				stale = append(stale, client)
+			}
+		}
+	}
+	return stale
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// historySize is how many plays a room remembers
+	historySize = 500
+	// completedWithin is how close to its end a song may stop and still
+	// count as completed
+	completedWithin = 5 * time.Second
+)
+
+type PlayOutcome string
+
+const (
+	PlayPlaying   PlayOutcome = "playing"
+	PlayCompleted PlayOutcome = "completed"
+	PlaySkipped   PlayOutcome = "skipped"
+)
+
+// PlayRecord is one song the room played. Song carries who requested it.
+type PlayRecord struct {
+	Song      Song        `json:"song"`
+	StartedAt int64       `json:"startedAt"`
+	EndedAt   int64       `json:"endedAt,omitempty"`
+	Outcome   PlayOutcome `json:"outcome"`
+}
+
+// endPlay closes the open record and queues it for the history log. Without an outcome, a song of known length
+// is completed if playback got within completedWithin of its end and skipped
+// otherwise; one of unknown length counts as completed. Call it before the
+// playback clock moves on.
+func (s *RoomState) endPlay(now int64, outcome PlayOutcome) {
+	rec := s.NowPlaying
+	if rec == nil {
+		return
+	}
+
+	if outcome == "" {
+		outcome = PlayCompleted
+		d, ok := parseTrackDuration(rec.Song.Duration)
+		played := time.Duration(s.Playback.positionAt(now)) * time.Millisecond
+		if ok && s.Playback.VideoID == rec.Song.VideoID && played < d-completedWithin {
+			outcome = PlaySkipped
+		}
+	}
+	rec.EndedAt = now
+	rec.Outcome = outcome
+	s.ended = append(s.ended, *rec)
+	s.NowPlaying = nil
+}
+
+// beginPlay closes whatever was playing and opens a record for song
+func (s *RoomState) beginPlay(song Song, now int64) {
+	s.endPlay(now, "")
+
+	song.Votes = nil
+	s.NowPlaying = &PlayRecord{Song: song, StartedAt: now, Outcome: PlayPlaying}
+}
+
+// GetHistory returns what the room has played, oldest first, if user may
+// see it
+func (h *Hub) GetHistory(roomID string, user *User) ([]PlayRecord, error) {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if !state.inUse() {
+		return nil, ErrRoomNotFound
+	}
+	if !state.canView(user) {
+		return nil, ErrRoomPrivate
+	}
+
+	records, err := readLog[PlayRecord](h, roomID, LogHistory)
+	if err != nil {
+		return nil, err
+	}
+	if state.NowPlaying != nil {
+		records = append(records, *state.NowPlaying)
+	}
+	for i := range records {
+		records[i].Song = publicSong(records[i].Song)
+	}
+	return records, nil
+}
+
+// ---- Export ----
+
+func songURL(videoID string) string {
+	return "https://music.youtube.com/watch?v=" + videoID
+}
+
+func formatMs(ms int64) string {
+	if ms == 0 {
+		return ""
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+// csvCell keeps a spreadsheet from running text as a formula: anyone can
+// name a song "=HYPERLINK(...)", so cells that would start one get a
+// leading quote
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// WriteHistoryCSV writes one row per play with a header row. Text that came
+// from users is escaped with csvCell.
+func WriteHistoryCSV(w io.Writer, records []PlayRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"started_at", "ended_at", "outcome", "title", "artists", "duration", "video_id", "url", "requested_by"})
+	for _, rec := range records {
+		requestedBy := ""
+		if rec.Song.RequestedBy != nil {
+			requestedBy = rec.Song.RequestedBy.Name
+		}
+		cw.Write([]string{
+			formatMs(rec.StartedAt),
+			formatMs(rec.EndedAt),
+			string(rec.Outcome),
+			csvCell(rec.Song.Title),
+			csvCell(strings.Join(rec.Song.Artists, "; ")),
+			rec.Song.Duration,
+			csvCell(rec.Song.VideoID),
+			songURL(rec.Song.VideoID),
+			csvCell(requestedBy),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteHistoryM3U writes the plays as an extended M3U playlist of YouTube
+// Music links
+func WriteHistoryM3U(w io.Writer, records []PlayRecord) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, rec := range records {
+		secs := -1
+		if d, ok := parseTrackDuration(rec.Song.Duration); ok {
+			secs = int(d.Seconds())
+		}
+		name := rec.Song.Title
+		if len(rec.Song.Artists) > 0 {
+			name = strings.Join(rec.Song.Artists, ", ") + " - " + name
+		}
+		// Line breaks would end the entry early
+		name = strings.NewReplacer("\r", " ", "\n", " ").Replace(name)
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n%s\n", secs, name, songURL(rec.Song.VideoID))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
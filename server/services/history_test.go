@@ -0,0 +1,47 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestWriteHistoryCSVEscapesFormulas(t *testing.T) {
+	records := []PlayRecord{{
+		Song: Song{
+			Title:       `=HYPERLINK("https://evil.example","click")`,
+			Artists:     []string{"+1", "Band"},
+			VideoID:     "-abc",
+			RequestedBy: &User{ID: "u1", Name: "@everyone"},
+		},
+	}, {
+		Song: Song{Title: "\tTabbed", Artists: []string{"\rReturn"}, VideoID: "vid"},
+	}, {
+		Song: Song{Title: "Plain - Title", Artists: []string{"A=B"}, VideoID: "vid"},
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteHistoryCSV(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// title, artists, video_id, requested_by
+	want := [][]string{
+		{`'=HYPERLINK("https://evil.example","click")`, "'+1; Band", "'-abc", "'@everyone"},
+		{"'\tTabbed", "'\rReturn", "vid", ""},
+		{"Plain - Title", "A=B", "vid", ""},
+	}
+	for i, w := range want {
+		row := rows[i+1]
+		got := []string{row[3], row[4], row[6], row[8]}
+		for j := range w {
+			if got[j] != w[j] {
+				t.Errorf("row %d cell %d = %q, want %q", i, j, got[j], w[j])
+			}
+		}
+	}
+}
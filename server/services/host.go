@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"log"
+)
+
+var errNotRoomMember = errors.New("user is not in this room")
+
+// hostMember returns the host's longest-present connection, if they are here
+func (s *RoomState) hostMember() *Member {
+	if s.HostID == "" {
+		return nil
+	}
+	return s.memberByUserID(s.HostID)
+}
+
+func (s *RoomState) memberByUserID(userID string) *Member {
+	for _, m := range s.Members {
+		if m.User != nil && m.User.ID == userID {
+			return m
+		}
+	}
+	return nil
+}
+
+// promoteHost hands the room to the longest-present member, preferring
+// those still connected, or leaves it unowned when nobody is left. Members
+// are kept in join order.
+func (s *RoomState) promoteHost() *User {
+	for _, connected := range []bool{true, false} {
+		for _, m := range s.Members {
+			if m.User != nil && (m.DisconnectedAt == nil || !connected) {
+				s.HostID = m.User.ID
+				return m.User
+			}
+		}
+	}
+	s.HostID = ""
+	return nil
+}
+
+// TransferHost hands control of the room from the current host to another member
+func (h *Hub) TransferHost(roomID string, from *Client, toUserID string) (newHost *User, status bool) {
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		if from.User == nil || state.HostID != from.User.ID {
+			return ErrNotRoomHost
+		}
+		target := state.memberByUserID(toUserID)
+		if target == nil {
+			return errNotRoomMember
+		}
+		state.HostID = toUserID
+		newHost = target.User
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to transfer host in room %s: %v", roomID, err)
+		return nil, false
+	}
+
+	return newHost, true
+}
+
+// Notify room when the host changes
+func (h *Hub) NotifyHostChange(roomID string, host *User) {
+	event := Event{
+		Type: "host_changed",
+		User: host,
+	}
+	h.Broadcast(roomID, event)
+}
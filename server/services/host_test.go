@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+)
+
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	hub, err := NewHub(NewMemoryRoomStore(), NewLocalBroker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hub.Close() })
+	return hub
+}
+
+// newTestClient is a connection with no socket behind it; frames sent to it
+// queue up unread
+func newTestClient(roomID, userID string, wantsHost bool) *Client {
+	return &Client{
+		ID:        "conn-" + userID,
+		Room:      roomID,
+		WantsHost: wantsHost,
+		User:      &User{ID: userID, Name: userID},
+		send:      make(chan []byte, sendBufferSize),
+		done:      make(chan struct{}),
+	}
+}
+
+func TestCreatorKeepsRoomWhileVisitorsComeAndGo(t *testing.T) {
+	for _, visitors := range [][]string{{"a"}, {"a", "b"}} {
+		hub := newTestHub(t)
+		creator := &User{ID: "creator", Name: "creator"}
+		room, err := hub.CreateRoom(creator, "Party", true, "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clients := map[string]*Client{}
+		for _, id := range visitors {
+			clients[id] = newTestClient(room.ID, id, false)
+			if hub.AddMember(room.ID, clients[id]) {
+				t.Fatalf("visitor %s became host of a room the creator owns", id)
+			}
+		}
+		hub.LeaveRoom(room.ID, clients[visitors[len(visitors)-1]], true)
+
+		state, err := hub.loadState(room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.HostID != creator.ID {
+			t.Fatalf("with visitors %v: host is %q, want the creator", visitors, state.HostID)
+		}
+		if err := hub.CloseRoom(room.ID, creator); err != nil {
+			t.Fatalf("with visitors %v: creator can't close the room: %v", visitors, err)
+		}
+	}
+}
+
+func TestHostLeavingHandsRoomOn(t *testing.T) {
+	hub := newTestHub(t)
+	host := newTestClient("room", "host", true)
+	guest := newTestClient("room", "guest", false)
+	if !hub.AddMember("room", host) {
+		t.Fatal("first client asking to host didn't get the room")
+	}
+	hub.AddMember("room", guest)
+
+	hub.LeaveRoom("room", host, true)
+
+	state, err := hub.loadState("room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.HostID != "guest" {
+		t.Fatalf("host is %q, want guest", state.HostID)
+	}
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// User tokens are HS256 tokens signed with JWT_SECRET, or RS256/ES256 tokens
+// signed by a key in the JWKS at JWT_JWKS (a file path or URL), picked by
+// the token's kid. Either or both may be configured, so a deployment can
+// move from the shared secret to keys, and rotate keys, without downtime.
+// JWT_ISSUER and JWT_AUDIENCE, when set, must match the iss and aud claims.
+
+const (
+	// jwksRefreshInterval is how long a fetched key set is trusted before
+	// it is fetched again
+	jwksRefreshInterval = 10 * time.Minute
+	// jwksMinRefresh stops unknown kids from hammering the JWKS endpoint
+	jwksMinRefresh   = 30 * time.Second
+	jwksFetchTimeout = 10 * time.Second
+	maxJWKSSize      = 1 << 20
+	// jwtLeeway allows for clock skew between us and the token issuer
+	jwtLeeway = 30 * time.Second
+)
+
+var (
+	errUnknownKey    = errors.New("no key with that kid")
+	errMissingKid    = errors.New("token has no kid")
+	errNoKeySet      = errors.New("asymmetric tokens are not accepted: JWT_JWKS is not set")
+	errNoSecret      = errors.New("HMAC tokens are not accepted: JWT_SECRET is not set")
+	errKeyAlgorithm  = errors.New("key is not for this algorithm")
+	errEmptyKeySet   = errors.New("key set has no usable keys")
+	errMissingUserID = errors.New("token has no user id")
+)
+
+// jwk is one JSON Web Key; only the RSA and P-256 EC members are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	key crypto.PublicKey
+	// alg is the algorithm the key is for: RS256 or ES256
+	alg string
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) publicKey() (publicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != "RS256" {
+			return publicKey{}, fmt.Errorf("unsupported RSA alg %q", k.Alg)
+		}
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("bad modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return publicKey{}, errors.New("bad exponent")
+		}
+		if n.BitLen() < 2048 {
+			return publicKey{}, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return publicKey{key: &rsa.PublicKey{N: n, E: int(e.Int64())}, alg: "RS256"}, nil
+
+	case "EC":
+		if k.Crv != "P-256" || (k.Alg != "" && k.Alg != "ES256") {
+			return publicKey{}, fmt.Errorf("unsupported EC key %s/%s", k.Crv, k.Alg)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != 32 {
+			return publicKey{}, errors.New("bad x coordinate")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) != 32 {
+			return publicKey{}, errors.New("bad y coordinate")
+		}
+		// Rejects points that aren't on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return publicKey{}, err
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		return publicKey{key: key, alg: "ES256"}, nil
+	}
+	return publicKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// parseJWKS reads a JWKS document, skipping keys it can't use
+func parseJWKS(data []byte) (map[string]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]publicKey)
+	for _, k := range set.Keys {
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errEmptyKeySet
+	}
+	return keys, nil
+}
+
+// KeySet holds the verification keys from a JWKS file or URL. It reloads
+// them every jwksRefreshInterval, and sooner when a token names a kid it
+// hasn't seen, which is how newly rotated keys are picked up. Reloads happen
+// outside the lock: a stale set keeps being used while one goroutine
+// fetches, and only callers after an unknown kid wait for the fetch.
+type KeySet struct {
+	source     string
+	client     *http.Client
+	minRefresh time.Duration
+
+	mux       sync.Mutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+	triedAt   time.Time
+	// refreshing is closed when the reload in flight finishes
+	refreshing chan struct{}
+}
+
+func NewKeySet(source string) *KeySet {
+	return &KeySet{
+		source:     source,
+		client:     &http.Client{Timeout: jwksFetchTimeout},
+		minRefresh: jwksMinRefresh,
+	}
+}
+
+func (s *KeySet) read() ([]byte, error) {
+	if !strings.HasPrefix(s.source, "https://") && !strings.HasPrefix(s.source, "http://") {
+		return os.ReadFile(s.source)
+	}
+
+	resp, err := s.client.Get(s.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS fetch returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// startRefresh reloads the keys in the background, unless that's already
+// under way, and returns a channel closed once the reload is done. On
+// failure the old keys stay in use. Caller must hold the lock.
+func (s *KeySet) startRefresh(now time.Time) <-chan struct{} {
+	if s.refreshing != nil {
+		return s.refreshing
+	}
+	done := make(chan struct{})
+	s.refreshing = done
+	s.triedAt = now
+
+	go func() {
+		defer close(done)
+
+		data, err := s.read()
+		var keys map[string]publicKey
+		if err == nil {
+			keys, err = parseJWKS(data)
+		}
+		if err != nil {
+			log.Printf("Failed to load JWKS from %s: %v", s.source, err)
+		}
+
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		if err == nil {
+			s.keys = keys
+			s.fetchedAt = now
+		}
+		s.refreshing = nil
+	}()
+	return done
+}
+
+// Key returns the key for kid
+func (s *KeySet) Key(kid string) (publicKey, error) {
+	s.mux.Lock()
+	now := time.Now()
+	key, known := s.keys[kid]
+	stale := now.Sub(s.fetchedAt) >= jwksRefreshInterval
+	var refreshed <-chan struct{}
+	if (stale || !known) && (s.refreshing != nil || now.Sub(s.triedAt) >= s.minRefresh) {
+		refreshed = s.startRefresh(now)
+	}
+	s.mux.Unlock()
+
+	if known {
+		return key, nil
+	}
+	if refreshed == nil {
+		return publicKey{}, errUnknownKey
+	}
+
+	<-refreshed
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if key, known = s.keys[kid]; !known {
+		return publicKey{}, errUnknownKey
+	}
+	return key, nil
+}
+
+// ---- Verification ----
+
+type jwtVerifier struct {
+	secret   []byte
+	keys     *KeySet
+	issuer   string
+	audience string
+}
+
+// userTokens verifies user tokens as configured in the environment
+var userTokens = sync.OnceValue(func() *jwtVerifier {
+	v := &jwtVerifier{
+		secret:   []byte(os.Getenv("JWT_SECRET")),
+		issuer:   os.Getenv("JWT_ISSUER"),
+		audience: os.Getenv("JWT_AUDIENCE"),
+	}
+	if source := os.Getenv("JWT_JWKS"); source != "" {
+		v.keys = NewKeySet(source)
+	}
+	return v
+})
+
+func (v *jwtVerifier) key(t *jwt.Token) (interface{}, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(v.secret) == 0 {
+			return nil, errNoSecret
+		}
+		return v.secret, nil
+
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		if v.keys == nil {
+			return nil, errNoKeySet
+		}
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errMissingKid
+		}
+		key, err := v.keys.Key(kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != t.Method.Alg() {
+			return nil, errKeyAlgorithm
+		}
+		return key.key, nil
+	}
+	return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+}
+
+func (v *jwtVerifier) options() []jwt.ParserOption {
+	var methods []string
+	if len(v.secret) > 0 {
+		methods = append(methods, "HS256")
+	}
+	if v.keys != nil {
+		methods = append(methods, "RS256", "ES256")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+	return opts
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksServer is a local stand-in for the auth service's JWKS endpoint
+type jwksServer struct {
+	*httptest.Server
+	mux  sync.Mutex
+	keys []map[string]string
+	// block, when set, holds requests until it is closed
+	block chan struct{}
+}
+
+func newJWKSServer(t *testing.T, keys ...map[string]string) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mux.Lock()
+		block, keys := s.block, s.keys
+		s.mux.Unlock()
+		if block != nil {
+			<-block
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...map[string]string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.keys = keys
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+		"n": b64(key.N.Bytes()),
+		"e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(x), "y": b64(y)}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseUser(t *testing.T) {
+	rsa1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsa2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ec1, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("shared-secret")
+
+	server := newJWKSServer(t, rsaJWK("rsa-1", rsa1), ecJWK("ec-1", ec1))
+	keys := NewKeySet(server.URL)
+	keys.minRefresh = 0
+	verifier := &jwtVerifier{
+		secret:   secret,
+		keys:     keys,
+		issuer:   "https://auth.example",
+		audience: "vybe",
+	}
+
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"id":   "user-1",
+			"name": "Ann",
+			"iss":  "https://auth.example",
+			"aud":  "vybe",
+			"exp":  time.Now().Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		rotate  bool
+		wantErr bool
+	}{
+		{
+			name:  "RS256",
+			token: func() string { return signToken(t, jwt.SigningMethodRS256, "rsa-1", rsa1, claims(nil)) },
+		},
+		{
+			name:  "ES256",
+			token: func() string { return signToken(t, jwt.SigningMethodES256, "ec-1", ec1, claims(nil)) },
+		},
+		{
+			name:  "HS256 with the shared secret",
+			token: func() string { return signToken(t, jwt.SigningMethodHS256, "", secret, claims(nil)) },
+		},
+		{
+			name: "audience list",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodRS256, "rsa-1", rsa1, claims(func(c jwt.MapClaims) { c["aud"] = []string{"other", "vybe"} }))
+			},
+		},
+		{
+			name: "sub instead of id",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodRS256, "rsa-1", rsa1, claims(func(c jwt.MapClaims) {
+					delete(c, "id")
+					c["sub"] = "user-1"
+				}))
+			},
+		},
+		{
+			name:    "kid not yet published",
+			token:   func() string { return signToken(t, jwt.SigningMethodRS256, "rsa-2", rsa2, claims(nil)) },
+			wantErr: true,
+		},
+		{
+			name:   "kid rotated in",
+			token:  func() string { return signToken(t, jwt.SigningMethodRS256, "rsa-2", rsa2, claims(nil)) },
+			rotate: true,
+		},
+		{
+			name:    "kid rotated out",
+			token:   func() string { return signToken(t, jwt.SigningMethodRS256, "rsa-1", rsa1, claims(nil)) },
+			wantErr: true,
+		},
+		{
+			name:    "ES256 token naming an RSA key",
+			token:   func() string { return signToken(t, jwt.SigningMethodES256, "rsa-2", ec1, claims(nil)) },
+			wantErr: true,
+		},
+		{
+			name:    "RS256 token naming an EC key",
+			token:   func() string { return signToken(t, jwt.SigningMethodRS256, "ec-1", rsa2, claims(nil)) },
+			wantErr: true,
+		},
+		{
+			name:    "signed by a different key",
+			token:   func() string { return signToken(t, jwt.SigningMethodRS256, "rsa-2", rsa1, claims(nil)) },
+			wantErr: true,
+		},
+		{
+			name:    "no kid",
+			token:   func() string { return signToken(t, jwt.SigningMethodRS256, "", rsa2, claims(nil)) },
+			wantErr: true,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodRS256, "rsa-2", rsa2, claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }))
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodRS256, "rsa-2", rsa2, claims(func(c jwt.MapClaims) { c["aud"] = "other" }))
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodRS256, "rsa-2", rsa2, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }))
+			},
+			wantErr: true,
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodRS256, "rsa-2", rsa2, claims(func(c jwt.MapClaims) { delete(c, "exp") }))
+			},
+			wantErr: true,
+		},
+		{
+			name: "no user id",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodRS256, "rsa-2", rsa2, claims(func(c jwt.MapClaims) { delete(c, "id") }))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "HS256 with another secret",
+			token:   func() string { return signToken(t, jwt.SigningMethodHS256, "", []byte("guess"), claims(nil)) },
+			wantErr: true,
+		},
+		{
+			name: "unsigned",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, claims(nil))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rotate {
+				server.setKeys(rsaJWK("rsa-2", rsa2), ecJWK("ec-1", ec1))
+			}
+			user, err := parseUser(verifier, tt.token())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted token for %+v", user)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != "user-1" || user.Name != "Ann" {
+				t.Fatalf("got user %+v", user)
+			}
+		})
+	}
+}
+
+func TestParseUserWithoutSecret(t *testing.T) {
+	verifier := &jwtVerifier{}
+	token := signToken(t, jwt.SigningMethodHS256, "", []byte{}, jwt.MapClaims{
+		"id":  "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	if _, err := parseUser(verifier, token); err == nil {
+		t.Fatal("accepted a token signed with an empty secret")
+	}
+}
+
+func TestKeySetServesCachedKeysWhileRefreshing(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	server := newJWKSServer(t, ecJWK("ec-1", key))
+	keys := NewKeySet(server.URL)
+	keys.minRefresh = 0
+
+	if _, err := keys.Key("ec-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the set stale and the endpoint hang
+	block := make(chan struct{})
+	release := sync.OnceFunc(func() { close(block) })
+	defer release()
+	server.mux.Lock()
+	server.block = block
+	server.mux.Unlock()
+	keys.mux.Lock()
+	keys.fetchedAt = time.Time{}
+	keys.mux.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := keys.Key("ec-1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Key waited for the refresh instead of using the cached key")
+	}
+
+	// An unknown kid waits for the refresh to finish before giving up
+	release()
+	if _, err := keys.Key("unknown"); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("got %v, want errUnknownKey", err)
+	}
+}
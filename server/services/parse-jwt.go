@@ -3,35 +3,43 @@ package services
 
 import (
     "fmt"
-    "os"
 
     "github.com/golang-jwt/jwt/v5"
 )
 
 type User struct {
     ID      string
-    Email   string
+    Email   string `json:",omitempty"`
     Name    string
     Picture string
 }
 
+// ParseJWT verifies a user token (see jwks.go for what is accepted) and
+// reads the user from its claims
 func ParseJWT(tokenString string) (*User, error) {
+    return parseUser(userTokens(), tokenString)
+}
+
+func parseUser(verifier *jwtVerifier, tokenString string) (*User, error) {
     claims := jwt.MapClaims{}
-    secret := []byte(os.Getenv("JWT_SECRET"))
 
-    token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-        if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-            return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-        }
-        return secret, nil
-    })
+    token, err := jwt.ParseWithClaims(tokenString, claims, verifier.key, verifier.options()...)
 
     if err != nil || !token.Valid {
         return nil, fmt.Errorf("invalid or expired token: %w", err)
     }
 
+    // The auth service puts the user's id in "id"; other issuers use "sub"
+    id, ok := claims["id"]
+    if !ok {
+        id, ok = claims["sub"]
+    }
+    if !ok || id == nil || fmt.Sprintf("%v", id) == "" {
+        return nil, errMissingUserID
+    }
+
     user := &User{
-        ID:      fmt.Sprintf("%v", claims["id"]),
+        ID:      fmt.Sprintf("%v", id),
         Email:   fmt.Sprintf("%v", claims["email"]),
         Name:    fmt.Sprintf("%v", claims["name"]),
         Picture: fmt.Sprintf("%v", claims["picture"]),
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Client requests and the payload each one carries. Requests without a
+// payload of their own use EmptyPayload.
+
+const (
+	maxVideoIDLength = 64
+	maxTitleLength   = 200
+	maxArtists       = 10
+	maxURLLength     = 2048
+)
+
+type payload interface {
+	Validate() error
+}
+
+var requestPayloads = map[string]func() payload{
+	"auth":            func() payload { return &AuthPayload{} },
+	"addToQueue":      func() payload { return &SongPayload{} },
+	"playNext":        func() payload { return &SongPayload{} },
+	"next":            func() payload { return &EmptyPayload{} },
+	"previous":        func() payload { return &EmptyPayload{} },
+	"play":            func() payload { return &PlayPayload{} },
+	"pause":           func() payload { return &PausePayload{} },
+	"seek":            func() payload { return &SeekPayload{} },
+	"removeFromQueue": func() payload { return &IndexPayload{} },
+	"moveInQueue":     func() payload { return &MovePayload{} },
+	"jumpTo":          func() payload { return &IndexPayload{} },
+	"clearQueue":      func() payload { return &EmptyPayload{} },
+	"transferHost":    func() payload { return &TargetPayload{} },
+	"setRole":         func() payload { return &SetRolePayload{} },
+	"setPolicy":       func() payload { return &SetPolicyPayload{} },
+	"setShuffle":      func() payload { return &ShufflePayload{} },
+	"setRepeat":       func() payload { return &RepeatPayload{} },
+	"voteSkip":        func() payload { return &EmptyPayload{} },
+	"upvote":          func() payload { return &IndexPayload{} },
+	"downvote":        func() payload { return &IndexPayload{} },
+	"unvote":          func() payload { return &IndexPayload{} },
+	"chat":            func() payload { return &ChatPayload{} },
+	"reaction":        func() payload { return &ReactionPayload{} },
+	"deleteMessage":   func() payload { return &DeleteMessagePayload{} },
+	"muteUser":        func() payload { return &TargetPayload{} },
+	"unmuteUser":      func() payload { return &TargetPayload{} },
+	"setAccess":       func() payload { return &SetAccessPayload{} },
+	"approveJoin":     func() payload { return &TargetPayload{} },
+	"denyJoin":        func() payload { return &TargetPayload{} },
+	"replay":          func() payload { return &ReplayPayload{} },
+	"sync":            func() payload { return &EmptyPayload{} },
+}
+
+var (
+	errMissingToken    = errors.New("token is required")
+	errInvalidSong     = errors.New("song needs a VideoID of at most 64 characters and a Title of at most 200")
+	errInvalidDuration = errors.New("duration must look like 3:45 or 1:02:03")
+	errInvalidArtists  = errors.New("song can list at most 10 artists of at most 200 characters each")
+	errInvalidThumb    = errors.New("thumbnail must be an http(s) URL of at most 2048 characters")
+	errMissingPosition = errors.New("positionMs is required")
+	errNegativePos     = errors.New("positionMs must not be negative")
+	errMissingIndex    = errors.New("index is required and must not be negative")
+	errMissingTarget   = errors.New("targetUserId is required")
+	errMissingPolicy   = errors.New("policy is required")
+	errMissingShuffle  = errors.New("shuffle is required")
+	errMissingMessage  = errors.New("messageId is required")
+	errEmptyAccess     = errors.New("set a password, knock, or both")
+	errMissingLastSeq  = errors.New("lastSeq is required and must not be negative")
+)
+
+type EmptyPayload struct{}
+
+func (p *EmptyPayload) Validate() error { return nil }
+
+// AuthPayload opens a connection. Password and Invite get past the room's
+// door; Session and LastSeq resume a dropped connection instead.
+type AuthPayload struct {
+	Token    string  `json:"token"`
+	Password *string `json:"password,omitempty"`
+	Invite   string  `json:"invite,omitempty"`
+	Session  string  `json:"session,omitempty"`
+	LastSeq  *int64  `json:"lastSeq,omitempty"`
+}
+
+func (p *AuthPayload) Validate() error {
+	if p.Token == "" {
+		return errMissingToken
+	}
+	if p.LastSeq != nil && *p.LastSeq < 0 {
+		return errMissingLastSeq
+	}
+	return nil
+}
+
+func validateSong(s Song) error {
+	if s.VideoID == "" || len(s.VideoID) > maxVideoIDLength || utf8.RuneCountInString(s.Title) > maxTitleLength {
+		return errInvalidSong
+	}
+	if _, ok := parseTrackDuration(s.Duration); s.Duration != "" && !ok {
+		return errInvalidDuration
+	}
+	if len(s.Artists) > maxArtists {
+		return errInvalidArtists
+	}
+	for _, artist := range s.Artists {
+		if utf8.RuneCountInString(artist) > maxTitleLength {
+			return errInvalidArtists
+		}
+	}
+	if s.Thumbnail != "" && (len(s.Thumbnail) > maxURLLength || !isHTTPURL(s.Thumbnail)) {
+		return errInvalidThumb
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+func validatePosition(positionMs *int64) error {
+	if positionMs != nil && *positionMs < 0 {
+		return errNegativePos
+	}
+	return nil
+}
+
+func validateIndex(index *int) error {
+	if index == nil || *index < 0 {
+		return errMissingIndex
+	}
+	return nil
+}
+
+type SongPayload struct {
+	Song Song `json:"song"`
+}
+
+func (p *SongPayload) Validate() error { return validateSong(p.Song) }
+
+// PlayPayload starts playback, switching to Song first when it's given
+type PlayPayload struct {
+	Song       *Song  `json:"song,omitempty"`
+	PositionMs *int64 `json:"positionMs,omitempty"`
+}
+
+func (p *PlayPayload) Validate() error {
+	if p.Song != nil {
+		if err := validateSong(*p.Song); err != nil {
+			return err
+		}
+	}
+	return validatePosition(p.PositionMs)
+}
+
+type PausePayload struct {
+	PositionMs *int64 `json:"positionMs,omitempty"`
+}
+
+func (p *PausePayload) Validate() error { return validatePosition(p.PositionMs) }
+
+type SeekPayload struct {
+	PositionMs *int64 `json:"positionMs"`
+}
+
+func (p *SeekPayload) Validate() error {
+	if p.PositionMs == nil {
+		return errMissingPosition
+	}
+	return validatePosition(p.PositionMs)
+}
+
+// IndexPayload points at a song in the queue
+type IndexPayload struct {
+	Index *int `json:"index"`
+}
+
+func (p *IndexPayload) Validate() error { return validateIndex(p.Index) }
+
+type MovePayload struct {
+	Index   *int `json:"index"`
+	ToIndex *int `json:"toIndex"`
+}
+
+func (p *MovePayload) Validate() error {
+	if err := validateIndex(p.Index); err != nil {
+		return err
+	}
+	return validateIndex(p.ToIndex)
+}
+
+// TargetPayload names the user a host action applies to
+type TargetPayload struct {
+	TargetUserID string `json:"targetUserId"`
+}
+
+func (p *TargetPayload) Validate() error {
+	if p.TargetUserID == "" {
+		return errMissingTarget
+	}
+	return nil
+}
+
+type SetRolePayload struct {
+	TargetUserID string `json:"targetUserId"`
+	Role         Role   `json:"role"`
+}
+
+func (p *SetRolePayload) Validate() error {
+	if p.TargetUserID == "" {
+		return errMissingTarget
+	}
+	if _, ok := roleRank[p.Role]; !ok || p.Role == RoleHost {
+		return errInvalidRole
+	}
+	return nil
+}
+
+type SetPolicyPayload struct {
+	Policy *RoomPolicy `json:"policy"`
+}
+
+func (p *SetPolicyPayload) Validate() error {
+	if p.Policy == nil {
+		return errMissingPolicy
+	}
+	return nil
+}
+
+type ShufflePayload struct {
+	Shuffle *bool `json:"shuffle"`
+}
+
+func (p *ShufflePayload) Validate() error {
+	if p.Shuffle == nil {
+		return errMissingShuffle
+	}
+	return nil
+}
+
+type RepeatPayload struct {
+	Repeat RepeatMode `json:"repeat"`
+}
+
+func (p *RepeatPayload) Validate() error {
+	if !validRepeat(p.Repeat) {
+		return errInvalidRepeat
+	}
+	return nil
+}
+
+type ChatPayload struct {
+	Text string `json:"text"`
+}
+
+func (p *ChatPayload) Validate() error {
+	_, err := validateChatText(p.Text)
+	return err
+}
+
+type ReactionPayload struct {
+	Emoji string `json:"emoji"`
+}
+
+func (p *ReactionPayload) Validate() error { return validateReaction(p.Emoji) }
+
+type DeleteMessagePayload struct {
+	MessageID string `json:"messageId"`
+}
+
+func (p *DeleteMessagePayload) Validate() error {
+	if p.MessageID == "" {
+		return errMissingMessage
+	}
+	return nil
+}
+
+// SetAccessPayload changes the door. A nil field is left as is; an empty
+// password removes it.
+type SetAccessPayload struct {
+	Password *string `json:"password,omitempty"`
+	Knock    *bool   `json:"knock,omitempty"`
+}
+
+func (p *SetAccessPayload) Validate() error {
+	if p.Password == nil && p.Knock == nil {
+		return errEmptyAccess
+	}
+	return nil
+}
+
+type ReplayPayload struct {
+	LastSeq *int64 `json:"lastSeq"`
+}
+
+func (p *ReplayPayload) Validate() error {
+	if p.LastSeq == nil || *p.LastSeq < 0 {
+		return errMissingLastSeq
+	}
+	return nil
+}
+
+// AuthSuccessPayload answers a successful auth
+type AuthSuccessPayload struct {
+	User    *User  `json:"user"`
+	IsHost  bool   `json:"isHost"`
+	Role    Role   `json:"role"`
+	Session string `json:"session"`
+	Resumed bool   `json:"resumed"`
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"slices"
+)
+
+type RepeatMode string
+
+const (
+	RepeatOff RepeatMode = "off"
+	RepeatOne RepeatMode = "one"
+	RepeatAll RepeatMode = "all"
+)
+
+var errInvalidRepeat = errors.New("repeat must be off, one or all")
+
+// PlayMode is how the room moves through its queue. Shuffling reorders the
+// songs still to come in the queue itself, so the queue always shows what
+// plays next and previous walks back through exactly what was heard.
+type PlayMode struct {
+	Shuffle bool `json:"shuffle"`
+	// Repeat is empty until a host sets it, which means off
+	Repeat RepeatMode `json:"repeat,omitempty"`
+}
+
+func (m PlayMode) repeat() RepeatMode {
+	if m.Repeat == "" {
+		return RepeatOff
+	}
+	return m.Repeat
+}
+
+func validRepeat(r RepeatMode) bool {
+	return r == RepeatOff || r == RepeatOne || r == RepeatAll
+}
+
+// nextIndex picks the song after the current one. trackEnded is true when
+// the song played out rather than being skipped; only then does repeat-one
+// play it again.
+func (s *RoomState) nextIndex(trackEnded bool) (int, bool) {
+	n := len(s.SongsQueue)
+	switch {
+	case n == 0:
+		return 0, false
+	case trackEnded && s.Mode.repeat() == RepeatOne && s.CurrentSongIdx >= 0:
+		return s.CurrentSongIdx, true
+	case s.CurrentSongIdx < n-1:
+		return s.CurrentSongIdx + 1, true
+	case s.Mode.repeat() != RepeatOff:
+		return 0, true
+	}
+	return 0, false
+}
+
+// previousIndex picks the song before the current one, wrapping to the end
+// when the whole queue repeats
+func (s *RoomState) previousIndex() (int, bool) {
+	n := len(s.SongsQueue)
+	switch {
+	case n == 0:
+		return 0, false
+	case s.CurrentSongIdx > 0:
+		return s.CurrentSongIdx - 1, true
+	case s.Mode.repeat() == RepeatAll:
+		return n - 1, true
+	}
+	return 0, false
+}
+
+// insertIndex is where a newly added song goes: the end of the queue, or a
+// random spot among the songs to come while shuffling
+func (s *RoomState) insertIndex() int {
+	if !s.Mode.Shuffle {
+		return len(s.SongsQueue)
+	}
+	first := s.CurrentSongIdx + 1
+	return first + rand.IntN(len(s.SongsQueue)-first+1)
+}
+
+// SetShuffle turns shuffle on or off. Turning it on remembers the order of
+// the songs to come and shuffles them; turning it off puts them back in that
+// order, with songs added in the meantime after them.
+func (h *Hub) SetShuffle(roomID string, shuffle bool) (mode PlayMode, queue []Song, currentIdx int, status bool) {
+	state, err := h.updateState(roomID, func(state *RoomState) error {
+		if state.Mode.Shuffle == shuffle {
+			return nil
+		}
+		state.Mode.Shuffle = shuffle
+
+		upcoming := state.upcoming()
+		if shuffle {
+			state.Unshuffled = make([]int64, len(upcoming))
+			for i, song := range upcoming {
+				state.Unshuffled[i] = song.AddedAt
+			}
+			rand.Shuffle(len(upcoming), func(i, j int) {
+				upcoming[i], upcoming[j] = upcoming[j], upcoming[i]
+			})
+		} else {
+			state.unshuffle()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to set shuffle in room %s: %v", roomID, err)
+		return PlayMode{}, nil, -1, false
+	}
+
+	return state.Mode, state.SongsQueue, state.CurrentSongIdx, true
+}
+
+// unshuffle restores the order saved when shuffle was turned on. AddedAt is
+// unique within the queue, so it identifies each song; songs that weren't
+// there then go last, in the order they were added.
+func (s *RoomState) unshuffle() {
+	rank := make(map[int64]int, len(s.Unshuffled))
+	for i, addedAt := range s.Unshuffled {
+		rank[addedAt] = i
+	}
+	s.Unshuffled = nil
+
+	slices.SortStableFunc(s.upcoming(), func(a, b Song) int {
+		ra, ok := rank[a.AddedAt]
+		if !ok {
+			ra = len(rank)
+		}
+		rb, ok := rank[b.AddedAt]
+		if !ok {
+			rb = len(rank)
+		}
+		return cmp.Or(cmp.Compare(ra, rb), cmp.Compare(a.AddedAt, b.AddedAt))
+	})
+}
+
+// SetRepeat changes the repeat mode
+func (h *Hub) SetRepeat(roomID string, repeat RepeatMode) (PlayMode, bool) {
+	state, err := h.updateState(roomID, func(state *RoomState) error {
+		if !validRepeat(repeat) {
+			return errInvalidRepeat
+		}
+		state.Mode.Repeat = repeat
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to set repeat in room %s: %v", roomID, err)
+		return PlayMode{}, false
+	}
+
+	h.autoplay(roomID)
+	return state.Mode, true
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// queueOf builds a queue of songs titled by letter, stamped 1, 2, 3... in
+// the order given
+func queueOf(titles string) []Song {
+	songs := make([]Song, len(titles))
+	for i, t := range titles {
+		songs[i] = Song{Title: string(t), VideoID: string(t), AddedAt: int64(i + 1)}
+	}
+	return songs
+}
+
+func titlesOf(songs []Song) string {
+	var b []byte
+	for _, s := range songs {
+		b = append(b, s.Title...)
+	}
+	return string(b)
+}
+
+func TestNextIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		n, cur     int
+		repeat     RepeatMode
+		trackEnded bool
+		want       int
+		wantOK     bool
+	}{
+		{"empty queue", 0, -1, RepeatOff, true, 0, false},
+		{"nothing played yet", 3, -1, RepeatOff, true, 0, true},
+		{"middle", 3, 1, RepeatOff, true, 2, true},
+		{"end without repeat", 3, 2, RepeatOff, true, 0, false},
+		{"end with repeat all", 3, 2, RepeatAll, true, 0, true},
+		{"end with repeat one", 3, 2, RepeatOne, true, 2, true},
+		{"repeat one skipped", 3, 1, RepeatOne, false, 2, true},
+		{"repeat one skipped at end", 3, 2, RepeatOne, false, 0, true},
+		{"repeat unset means off", 3, 2, "", true, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &RoomState{
+				SongsQueue:     queueOf("ABCDEFGHIJ"[:tt.n]),
+				CurrentSongIdx: tt.cur,
+				Mode:           PlayMode{Repeat: tt.repeat},
+			}
+			got, ok := s.nextIndex(tt.trackEnded)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Fatalf("nextIndex = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPreviousIndex(t *testing.T) {
+	tests := []struct {
+		name   string
+		n, cur int
+		repeat RepeatMode
+		want   int
+		wantOK bool
+	}{
+		{"empty queue", 0, -1, RepeatOff, 0, false},
+		{"middle", 3, 2, RepeatOff, 1, true},
+		{"start without repeat", 3, 0, RepeatOff, 0, false},
+		{"start with repeat all", 3, 0, RepeatAll, 2, true},
+		{"start with repeat one", 3, 0, RepeatOne, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &RoomState{
+				SongsQueue:     queueOf("ABCDEFGHIJ"[:tt.n]),
+				CurrentSongIdx: tt.cur,
+				Mode:           PlayMode{Repeat: tt.repeat},
+			}
+			got, ok := s.previousIndex()
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Fatalf("previousIndex = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUnshuffle(t *testing.T) {
+	tests := []struct {
+		name       string
+		queue      []Song
+		cur        int
+		unshuffled []int64
+		want       string
+	}{
+		{
+			name:       "restores the saved order",
+			queue:      pick(queueOf("ABCDE"), 0, 3, 1, 4, 2),
+			cur:        0,
+			unshuffled: []int64{2, 3, 4, 5},
+			want:       "ABCDE",
+		},
+		{
+			name: "keeps an order that wasn't the order added",
+			// E was moved up before shuffling
+			queue:      pick(queueOf("ABCDE"), 0, 2, 4, 1, 3),
+			cur:        0,
+			unshuffled: []int64{5, 2, 3, 4},
+			want:       "AEBCD",
+		},
+		{
+			name:       "songs added while shuffled go last in the order added",
+			queue:      pick(queueOf("ABCDEF"), 0, 5, 2, 4, 1, 3),
+			cur:        0,
+			unshuffled: []int64{2, 3, 4},
+			want:       "ABCDEF",
+		},
+		{
+			name:       "removed songs are skipped",
+			queue:      pick(queueOf("ABCD"), 0, 3, 1),
+			cur:        0,
+			unshuffled: []int64{2, 3, 4},
+			want:       "ABD",
+		},
+		{
+			name:       "songs already played stay put",
+			queue:      pick(queueOf("ABCDE"), 2, 0, 4, 3, 1),
+			cur:        1,
+			unshuffled: []int64{2, 4, 5},
+			want:       "CABDE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &RoomState{SongsQueue: tt.queue, CurrentSongIdx: tt.cur, Unshuffled: tt.unshuffled}
+			s.unshuffle()
+			if got := titlesOf(s.SongsQueue); got != tt.want {
+				t.Fatalf("queue = %s, want %s", got, tt.want)
+			}
+			if s.Unshuffled != nil {
+				t.Fatalf("saved order kept after restoring it: %v", s.Unshuffled)
+			}
+		})
+	}
+}
+
+// pick reorders songs by index
+func pick(songs []Song, order ...int) []Song {
+	picked := make([]Song, len(order))
+	for i, j := range order {
+		picked[i] = songs[j]
+	}
+	return picked
+}
+
+func TestSetShuffleRoundTrip(t *testing.T) {
+	hub := newTestHub(t)
+	queue := queueOf("ABCDEFGH")
+	// A DJ moved H up to play next
+	queue = slices.Insert(slices.Delete(queue, 7, 8), 2, queue[7])
+	hub.store.Update(context.Background(), "room", func(s *RoomState) error {
+		s.SongsQueue = queue
+		s.CurrentSongIdx = 1
+		return nil
+	})
+	before := titlesOf(queue)
+
+	if _, _, _, ok := hub.SetShuffle("room", true); !ok {
+		t.Fatal("couldn't turn shuffle on")
+	}
+	_, shuffled, cur, ok := hub.SetShuffle("room", false)
+	if !ok {
+		t.Fatal("couldn't turn shuffle off")
+	}
+	if got := titlesOf(shuffled); got != before || cur != 1 {
+		t.Fatalf("queue = %s at %d, want %s at 1", got, cur, before)
+	}
+}
+
+func TestMoveInQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		cur      int
+		from, to int
+		want     string
+		wantCur  int
+	}{
+		{"playing song moves", 1, 1, 3, "ACDBE", 3},
+		{"from before to after", 2, 0, 4, "BCDEA", 1},
+		{"from before to the cursor", 2, 0, 2, "BCADE", 1},
+		{"from after to before", 2, 4, 0, "EABCD", 3},
+		{"from after to the cursor", 2, 4, 2, "ABECD", 3},
+		{"both after", 1, 2, 4, "ABDEC", 1},
+		{"both before", 3, 0, 2, "BCADE", 3},
+		{"in place", 2, 3, 3, "ABCDE", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newTestHub(t)
+			hub.store.Update(context.Background(), "room", func(s *RoomState) error {
+				s.SongsQueue = queueOf("ABCDE")
+				s.CurrentSongIdx = tt.cur
+				return nil
+			})
+
+			queue, cur, ok := hub.MoveInQueue("room", tt.from, tt.to)
+			if !ok {
+				t.Fatal("move rejected")
+			}
+			if got := titlesOf(queue); got != tt.want || cur != tt.wantCur {
+				t.Fatalf("queue = %s at %d, want %s at %d", got, cur, tt.want, tt.wantCur)
+			}
+		})
+	}
+}
+
+func TestMoveInQueueOutOfRange(t *testing.T) {
+	hub := newTestHub(t)
+	hub.store.Update(context.Background(), "room", func(s *RoomState) error {
+		s.SongsQueue = queueOf("ABC")
+		return nil
+	})
+	for _, move := range [][2]int{{-1, 0}, {0, 3}, {3, 0}} {
+		if _, _, ok := hub.MoveInQueue("room", move[0], move[1]); ok {
+			t.Errorf("move %v accepted", move)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+// syncInterval is how often every room gets a fresh playback snapshot, so
+// listeners can correct drift even when the host is idle.
+const syncInterval = 5 * time.Second
+
+// PlaybackState is the server's view of what the room is playing.
+// PositionMs is the position at UpdatedAt (unix ms); while Playing, clients
+// add the time elapsed since then.
+type PlaybackState struct {
+	VideoID    string `json:"videoId"`
+	PositionMs int64  `json:"positionMs"`
+	Playing    bool   `json:"playing"`
+	UpdatedAt  int64  `json:"updatedAt"`
+}
+
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// positionAt projects the playback position to the given server time
+func (p PlaybackState) positionAt(at int64) int64 {
+	if !p.Playing || p.UpdatedAt == 0 {
+		return p.PositionMs
+	}
+	return p.PositionMs + max(at-p.UpdatedAt, 0)
+}
+
+// start resets the clock to the beginning of a song and plays it
+func (p *PlaybackState) start(videoID string) {
+	p.VideoID = videoID
+	p.PositionMs = 0
+	p.Playing = true
+	p.UpdatedAt = nowMs()
+}
+
+// ---- Playback Control ----
+
+// inQueue reports whether videoID is already in the room's queue
+func (h *Hub) inQueue(roomID, videoID string) bool {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(state.SongsQueue, func(s Song) bool {
+		return s.VideoID == videoID
+	})
+}
+
+// Play resumes playback, optionally switching song and/or position. A song
+// that is already in the queue also moves the queue cursor to it, and the
+// queued copy, with its attribution, is returned in place of the client's.
+func (h *Hub) Play(roomID string, song Song, positionMs *int64) (PlaybackState, Song, bool) {
+	song.RequestedBy, song.AddedAt, song.AutoAdded, song.Votes = nil, 0, false, nil
+	song.Duration = ""
+	// A queued song is swapped for its queued copy, which has its length
+	if song.VideoID != "" && !h.inQueue(roomID, song.VideoID) {
+		h.resolveDuration(&song)
+	}
+
+	state, err := h.updateState(roomID, func(state *RoomState) error {
+		now := nowMs()
+		pb := &state.Playback
+
+		if song.VideoID != "" && song.VideoID != pb.VideoID {
+			state.SkipVotes = nil
+			for i, s := range state.SongsQueue {
+				if s.VideoID == song.VideoID {
+					state.CurrentSongIdx = i
+					song = s
+					break
+				}
+			}
+			state.beginPlay(song, now)
+			pb.VideoID = song.VideoID
+			pb.PositionMs = 0
+		} else {
+			pb.PositionMs = pb.positionAt(now)
+		}
+
+		if current, ok := state.currentSong(); ok && pb.VideoID == "" {
+			state.beginPlay(current, now)
+			pb.VideoID = current.VideoID
+		}
+		if positionMs != nil {
+			pb.PositionMs = max(*positionMs, 0)
+		}
+		pb.Playing = true
+		pb.UpdatedAt = now
+		return nil
+	})
+	h.autoplay(roomID)
+	if err != nil {
+		log.Printf("Failed to play in room %s: %v", roomID, err)
+		return PlaybackState{}, Song{}, false
+	}
+
+	return state.Playback, song, true
+}
+
+// Pause freezes the clock at the current (or given) position
+func (h *Hub) Pause(roomID string, positionMs *int64) (PlaybackState, bool) {
+	state, err := h.updateState(roomID, func(state *RoomState) error {
+		now := nowMs()
+		pb := &state.Playback
+
+		pb.PositionMs = pb.positionAt(now)
+		if positionMs != nil {
+			pb.PositionMs = max(*positionMs, 0)
+		}
+		pb.Playing = false
+		pb.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to pause in room %s: %v", roomID, err)
+		return PlaybackState{}, false
+	}
+
+	return state.Playback, true
+}
+
+// Seek moves the clock without changing play/pause
+func (h *Hub) Seek(roomID string, positionMs int64) (PlaybackState, bool) {
+	state, err := h.updateState(roomID, func(state *RoomState) error {
+		pb := &state.Playback
+		pb.PositionMs = max(positionMs, 0)
+		pb.UpdatedAt = nowMs()
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to seek in room %s: %v", roomID, err)
+		return PlaybackState{}, false
+	}
+
+	return state.Playback, true
+}
+
+// Playback returns the room's current playback state
+func (h *Hub) Playback(roomID string) (PlaybackState, bool) {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
+		return PlaybackState{}, false
+	}
+
+	return state.Playback, true
+}
+
+// ---- Clock Sync ----
+
+func syncEvent(pb PlaybackState) Event {
+	return Event{
+		Type:       "sync",
+		Playback:   &pb,
+		ServerTime: nowMs(),
+	}
+}
+
+// SendSync sends a playback snapshot to a single client
+func (h *Hub) SendSync(c *Client) {
+	pb, ok := h.Playback(c.Room)
+	if !ok {
+		return
+	}
+	h.Send(c, syncEvent(pb))
+}
+
+// runSyncLoop periodically sends a snapshot to every room with local clients.
+// Each instance only serves its own connections, so this goes through deliver
+// rather than the broker.
+func (h *Hub) runSyncLoop() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			for _, roomID := range h.localRooms() {
+				state, err := h.loadState(roomID)
+				if err != nil {
+					log.Printf("Failed to load room %s: %v", roomID, err)
+					continue
+				}
+				// Picks up playback changed by other instances
+				h.scheduleAdvance(roomID, state)
+				if state.Playback.VideoID == "" {
+					continue
+				}
+				h.deliverEvent(roomID, syncEvent(state.Playback))
+			}
+		}
+	}
+}
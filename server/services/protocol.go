@@ -0,0 +1,152 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ProtocolVersion is the envelope version this server speaks. Frames with any
+// other "v" are refused rather than guessed at.
+const ProtocolVersion = 1
+
+// Envelope is the frame every message travels in, both ways. ID is chosen by
+// the client and echoed on the ack or error that answers the request; Seq is
+// set on room broadcasts (see the event log).
+type Envelope struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Seq     int64           `json:"seq,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// ErrorCode says what kind of problem an error event reports
+type ErrorCode string
+
+const (
+	// CodeBadRequest means the frame wasn't a JSON envelope at all
+	CodeBadRequest         ErrorCode = "bad_request"
+	CodeUnsupportedVersion ErrorCode = "unsupported_version"
+	CodeUnknownType        ErrorCode = "unknown_type"
+	CodeInvalidPayload     ErrorCode = "invalid_payload"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	// CodeForbidden means the sender's role doesn't allow the request
+	CodeForbidden ErrorCode = "forbidden"
+	// CodeRejected means the request was understood but couldn't be carried
+	// out, e.g. an index that's out of range
+	CodeRejected ErrorCode = "rejected"
+	// CodeRateLimited comes with RetryAfterMs
+	CodeRateLimited ErrorCode = "rate_limited"
+)
+
+// ProtocolError is the payload of an "error" event
+type ProtocolError struct {
+	Code         ErrorCode `json:"code"`
+	Message      string    `json:"message"`
+	RetryAfterMs int64     `json:"retryAfterMs,omitempty"`
+}
+
+func NewProtocolError(code ErrorCode, format string, args ...any) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Request is a decoded, validated client message. Payload is a pointer to
+// the payload struct registered for Type.
+type Request struct {
+	ID      string
+	Type    string
+	Payload any
+}
+
+// DecodeRequest unwraps and validates a client frame. On failure the error
+// is a *ProtocolError, and the returned Request still carries the ID when it
+// could be read so the error can be correlated.
+func DecodeRequest(data []byte) (Request, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Type == "" {
+		return Request{}, NewProtocolError(CodeBadRequest, "expected a JSON envelope with a type")
+	}
+
+	req := Request{ID: env.ID, Type: env.Type}
+	if env.Version != ProtocolVersion {
+		return req, NewProtocolError(CodeUnsupportedVersion, "protocol version %d is not supported, use %d", env.Version, ProtocolVersion)
+	}
+
+	newPayload, ok := requestPayloads[env.Type]
+	if !ok {
+		return req, NewProtocolError(CodeUnknownType, "unknown event type %q", env.Type)
+	}
+
+	payload := newPayload()
+	if len(env.Payload) > 0 && !bytes.Equal(env.Payload, []byte("null")) {
+		dec := json.NewDecoder(bytes.NewReader(env.Payload))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(payload); err != nil {
+			return req, NewProtocolError(CodeInvalidPayload, "%s payload: %v", env.Type, err)
+		}
+	}
+	if err := payload.Validate(); err != nil {
+		return req, NewProtocolError(CodeInvalidPayload, "%s payload: %v", env.Type, err)
+	}
+
+	req.Payload = payload
+	return req, nil
+}
+
+// encodeEvent wraps an event in an envelope; the event's fields become the
+// payload
+func encodeEvent(event Event) ([]byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		Version: ProtocolVersion,
+		Type:    event.Type,
+		Seq:     event.Seq,
+		Payload: payload,
+	})
+}
+
+// Reply sends one client a typed payload, correlated with the request ID
+// that prompted it
+func (h *Hub) Reply(c *Client, id, eventType string, payload any) {
+	env := Envelope{Version: ProtocolVersion, ID: id, Type: eventType}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("Failed to encode %s payload: %v", eventType, err)
+			return
+		}
+		env.Payload = data
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Failed to encode %s envelope: %v", eventType, err)
+		return
+	}
+	c.sendFrame(newFrame(data))
+}
+
+// Ack tells a client its request went through
+func (h *Hub) Ack(c *Client, id string) {
+	h.Reply(c, id, "ack", nil)
+}
+
+// SendError tells a client its request failed. Errors that aren't a
+// *ProtocolError are reported as rejections.
+func (h *Hub) SendError(c *Client, id string, err error) {
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		perr = NewProtocolError(CodeRejected, "%v", err)
+	}
+	h.Reply(c, id, "error", perr)
+}
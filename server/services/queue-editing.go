@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"slices"
+)
+
+var (
+	errInvalidIndex    = errors.New("queue index out of range")
+	errRemovingCurrent = errors.New("cannot remove the song that is playing, skip it instead")
+)
+
+// editQueue runs a queue edit and returns the resulting queue and cursor
+func (h *Hub) editQueue(roomID, action string, fn func(*RoomState) error) (queue []Song, currentIdx int, status bool) {
+	state, err := h.updateState(roomID, fn)
+	if err != nil {
+		log.Printf("Failed to %s in room %s: %v", action, roomID, err)
+		return nil, -1, false
+	}
+
+	return state.SongsQueue, state.CurrentSongIdx, true
+}
+
+// RemoveFromQueue drops the song at index. The playing song can't be removed.
+func (h *Hub) RemoveFromQueue(roomID string, index int) (queue []Song, currentIdx int, status bool) {
+	return h.editQueue(roomID, "remove from queue", func(state *RoomState) error {
+		if index < 0 || index >= len(state.SongsQueue) {
+			return errInvalidIndex
+		}
+		if index == state.CurrentSongIdx {
+			return errRemovingCurrent
+		}
+
+		state.SongsQueue = slices.Delete(state.SongsQueue, index, index+1)
+		if index < state.CurrentSongIdx {
+			state.CurrentSongIdx--
+		}
+		return nil
+	})
+}
+
+// MoveInQueue moves the song at from to position to, keeping the cursor on
+// the same song
+func (h *Hub) MoveInQueue(roomID string, from, to int) (queue []Song, currentIdx int, status bool) {
+	return h.editQueue(roomID, "move in queue", func(state *RoomState) error {
+		n := len(state.SongsQueue)
+		if from < 0 || from >= n || to < 0 || to >= n {
+			return errInvalidIndex
+		}
+
+		song := state.SongsQueue[from]
+		state.SongsQueue = slices.Delete(state.SongsQueue, from, from+1)
+		state.SongsQueue = slices.Insert(state.SongsQueue, to, song)
+
+		cur := state.CurrentSongIdx
+		switch {
+		case from == cur:
+			state.CurrentSongIdx = to
+		case from < cur && to >= cur:
+			state.CurrentSongIdx--
+		case from > cur && to <= cur:
+			state.CurrentSongIdx++
+		}
+		return nil
+	})
+}
+
+// PlayNext inserts a song for the client right after the one playing. The
+// queue policy applies just as it does to AddSong.
+func (h *Hub) PlayNext(roomID string, c *Client, song Song) (queue []Song, currentIdx int, err error) {
+	h.resolveDuration(&song)
+	state, err := h.updateState(roomID, func(state *RoomState) error {
+		if err := state.checkQueuePolicy(song, c.User.ID); err != nil {
+			return err
+		}
+		state.stampQueued(&song, c.User)
+		state.SongsQueue = slices.Insert(state.SongsQueue, state.CurrentSongIdx+1, song)
+		if state.CurrentSongIdx == -1 {
+			state.CurrentSongIdx = 0
+		}
+		return nil
+	})
+	if err != nil {
+		var rejection *QueueRejection
+		if !errors.As(err, &rejection) {
+			log.Printf("Failed to play next in room %s: %v", roomID, err)
+		}
+		return nil, -1, err
+	}
+
+	return state.SongsQueue, state.CurrentSongIdx, nil
+}
+
+// JumpTo moves the cursor to index and starts that song from the top
+func (h *Hub) JumpTo(roomID string, index int) (song Song, status bool) {
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		if index < 0 || index >= len(state.SongsQueue) {
+			return errInvalidIndex
+		}
+		song = state.startSong(index)
+		return nil
+	})
+	h.autoplay(roomID)
+	if err != nil {
+		log.Printf("Failed to jump in room %s: %v", roomID, err)
+		return Song{}, false
+	}
+
+	return song, true
+}
+
+// ClearQueue wipes everything after the playing song; history and the
+// current song stay
+func (h *Hub) ClearQueue(roomID string) (queue []Song, currentIdx int, status bool) {
+	return h.editQueue(roomID, "clear queue", func(state *RoomState) error {
+		state.SongsQueue = state.SongsQueue[:state.CurrentSongIdx+1]
+		return nil
+	})
+}
+
+// BroadcastQueue sends the whole queue and cursor to the room
+func (h *Hub) BroadcastQueue(roomID string, queue []Song, currentIdx int) {
+	h.Broadcast(roomID, Event{
+		Type:           "queue_updated",
+		Queue:          queue,
+		CurrentSongIdx: &currentIdx,
+	})
+}
+
+// QueueInfo is a room's queue with who asked for each song
+type QueueInfo struct {
+	Songs          []Song `json:"songs"`
+	CurrentSongIdx int    `json:"currentSongIdx"`
+}
+
+// GetQueue returns the room's queue and cursor, if user may see them
+func (h *Hub) GetQueue(roomID string, user *User) (QueueInfo, error) {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		return QueueInfo{}, err
+	}
+	if !state.inUse() {
+		return QueueInfo{}, ErrRoomNotFound
+	}
+	if !state.canView(user) {
+		return QueueInfo{}, ErrRoomPrivate
+	}
+
+	return QueueInfo{Songs: publicSongs(state.SongsQueue), CurrentSongIdx: state.CurrentSongIdx}, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueuePolicy limits what can be added to the queue. A zero field turns
+// that limit off.
+type QueuePolicy struct {
+	// MaxLength caps the songs waiting after the one playing
+	MaxLength int `json:"maxLength,omitempty"`
+	// MaxPerUser caps how many of those waiting songs one member may own
+	MaxPerUser int `json:"maxPerUser,omitempty"`
+	// NoRepeatWithin rejects a song already among the last N in the queue
+	NoRepeatWithin int `json:"noRepeatWithin,omitempty"`
+	// MaxDurationSec rejects longer tracks, and tracks whose length can't be
+	// found out
+	MaxDurationSec int `json:"maxDurationSec,omitempty"`
+}
+
+func (p QueuePolicy) valid() bool {
+	return p.MaxLength >= 0 && p.MaxPerUser >= 0 && p.NoRepeatWithin >= 0 && p.MaxDurationSec >= 0
+}
+
+// QueueRejection is a song turned away by the queue policy. Reason is
+// written for the member who asked.
+type QueueRejection struct {
+	Reason string
+}
+
+func (e *QueueRejection) Error() string {
+	return e.Reason
+}
+
+func rejectSong(format string, args ...any) error {
+	return &QueueRejection{Reason: fmt.Sprintf(format, args...)}
+}
+
+// checkQueuePolicy reports why song can't be appended for userID, if it
+// can't
+func (s *RoomState) checkQueuePolicy(song Song, userID string) error {
+	p := s.Policy.Queue
+	upcoming := s.upcoming()
+
+	if p.MaxLength > 0 && len(upcoming) >= p.MaxLength {
+		return rejectSong("the queue is full (%d songs)", p.MaxLength)
+	}
+
+	if p.MaxPerUser > 0 {
+		mine := 0
+		for _, queued := range upcoming {
+			if queued.RequestedBy != nil && queued.RequestedBy.ID == userID {
+				mine++
+			}
+		}
+		if mine >= p.MaxPerUser {
+			return rejectSong("you already have %d songs waiting", mine)
+		}
+	}
+
+	if p.NoRepeatWithin > 0 {
+		recent := s.SongsQueue[max(0, len(s.SongsQueue)-p.NoRepeatWithin):]
+		for _, queued := range recent {
+			if queued.VideoID == song.VideoID {
+				return rejectSong("that song was queued within the last %d songs", p.NoRepeatWithin)
+			}
+		}
+	}
+
+	if p.MaxDurationSec > 0 {
+		limit := time.Duration(p.MaxDurationSec) * time.Second
+		d, ok := parseTrackDuration(song.Duration)
+		if !ok {
+			return rejectSong("couldn't check the song's length, and songs can be at most %s long", formatTrackDuration(limit))
+		}
+		if d > limit {
+			return rejectSong("songs can be at most %s long", formatTrackDuration(limit))
+		}
+	}
+
+	return nil
+}
+
+// parseTrackDuration reads durations the way search results give them:
+// "3:45" or "1:02:03"
+func parseTrackDuration(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+
+	var total time.Duration
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		total = total*60 + time.Duration(n)
+	}
+	return total * time.Second, true
+}
+
+func formatTrackDuration(d time.Duration) string {
+	secs := int(d.Seconds())
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: Burst requests at once, refilled at Burst per
+// Per. A zero RateLimit means unlimited.
+type RateLimit struct {
+	Burst int
+	Per   time.Duration
+}
+
+func (l RateLimit) unlimited() bool {
+	return l.Burst <= 0 || l.Per <= 0
+}
+
+// perMs is the refill rate in tokens per millisecond
+func (l RateLimit) perMs() float64 {
+	return float64(l.Burst) / float64(l.Per.Milliseconds())
+}
+
+// RateLimits configures every limit by name. Events limit each user per
+// socket event type, Rooms limit everyone in a room together, and Routes
+// limit each user (or IP, when anonymous) per HTTP route.
+type RateLimits struct {
+	Events map[string]RateLimit
+	Rooms  map[string]RateLimit
+	Routes map[string]RateLimit
+}
+
+// DefaultRateLimits are generous enough for people and tight enough to stop
+// scripts. Search and transify spawn subprocesses, so they're the strictest.
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Events: map[string]RateLimit{
+			"addToQueue": {Burst: 10, Per: time.Minute},
+			"playNext":   {Burst: 10, Per: time.Minute},
+			"chat":       {Burst: 5, Per: 10 * time.Second},
+			"reaction":   {Burst: 5, Per: 10 * time.Second},
+			"upvote":     {Burst: 30, Per: time.Minute},
+			"downvote":   {Burst: 30, Per: time.Minute},
+			"unvote":     {Burst: 30, Per: time.Minute},
+			"voteSkip":   {Burst: 10, Per: time.Minute},
+			"replay":     {Burst: 10, Per: time.Minute},
+			"sync":       {Burst: 30, Per: time.Minute},
+			// password counts attempts at a room's password when joining
+			"password": {Burst: 5, Per: 10 * time.Minute},
+		},
+		Rooms: map[string]RateLimit{
+			"addToQueue": {Burst: 60, Per: time.Minute},
+		},
+		Routes: map[string]RateLimit{
+			"search":        {Burst: 20, Per: time.Minute},
+			"youtubeSearch": {Burst: 20, Per: time.Minute},
+			"transify":      {Burst: 5, Per: time.Minute},
+			"stream":        {Burst: 60, Per: time.Minute},
+			"createRoom":    {Burst: 10, Per: time.Minute},
+			"listRooms":     {Burst: 30, Per: time.Minute},
+		},
+	}
+}
+
+// LoadRateLimits starts from the defaults and applies overrides from
+// RATE_LIMITS, e.g. "event:addToQueue=20/1m,route:search=0/1m". A burst of 0
+// turns a limit off.
+func LoadRateLimits() RateLimits {
+	limits := DefaultRateLimits()
+
+	spec := strings.TrimSpace(os.Getenv("RATE_LIMITS"))
+	if spec == "" {
+		return limits
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		if err := limits.apply(strings.TrimSpace(entry)); err != nil {
+			log.Printf("Ignoring rate limit %q: %v", entry, err)
+		}
+	}
+	return limits
+}
+
+func (l *RateLimits) apply(entry string) error {
+	scoped, value, ok := strings.Cut(entry, "=")
+	if !ok {
+		return fmt.Errorf("expected scope:name=burst/period")
+	}
+	scope, name, ok := strings.Cut(scoped, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("expected scope:name=burst/period")
+	}
+	burst, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("expected burst/period")
+	}
+
+	n, err := strconv.Atoi(burst)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid burst %q", burst)
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return fmt.Errorf("invalid period %q", period)
+	}
+
+	limit := RateLimit{Burst: n, Per: per}
+	switch scope {
+	case "event":
+		l.Events[name] = limit
+	case "room":
+		l.Rooms[name] = limit
+	case "route":
+		l.Routes[name] = limit
+	default:
+		return fmt.Errorf("unknown scope %q", scope)
+	}
+	return nil
+}
+
+// RateLimitStore keeps token buckets. Take spends a token from the bucket
+// under key, reporting how long to wait when there is none.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// RateLimiter applies RateLimits to socket events and HTTP routes. When the
+// store fails, requests are let through rather than locking everyone out.
+type RateLimiter struct {
+	store  RateLimitStore
+	limits RateLimits
+}
+
+func NewRateLimiter(store RateLimitStore, limits RateLimits) *RateLimiter {
+	return &RateLimiter{store: store, limits: limits}
+}
+
+func (r *RateLimiter) take(key string, limit RateLimit) (bool, time.Duration) {
+	if limit.unlimited() {
+		return true, 0
+	}
+	ok, retryAfter, err := r.store.Take(context.Background(), key, limit)
+	if err != nil {
+		log.Printf("Rate limit check for %s failed: %v", key, err)
+		return true, 0
+	}
+	return ok, retryAfter
+}
+
+// AllowEvent checks a socket event against the sender's own limit and then
+// the room's shared one
+func (r *RateLimiter) AllowEvent(roomID, userID, eventType string) (bool, time.Duration) {
+	if ok, retryAfter := r.take("event:"+eventType+":"+userID, r.limits.Events[eventType]); !ok {
+		return false, retryAfter
+	}
+	return r.take("room:"+eventType+":"+roomID, r.limits.Rooms[eventType])
+}
+
+// AllowPassword checks a user's attempts at one room's password, so the
+// password can't be guessed by reconnecting over and over
+func (r *RateLimiter) AllowPassword(roomID, userID string) (bool, time.Duration) {
+	return r.take("password:"+roomID+":"+userID, r.limits.Events["password"])
+}
+
+// AllowRoute checks an HTTP request against a route's limit. key is the
+// caller's user ID, or their IP when they aren't signed in.
+func (r *RateLimiter) AllowRoute(route, key string) (bool, time.Duration) {
+	return r.take("route:"+route+":"+key, r.limits.Routes[route])
+}
+
+// ---- In-memory store ----
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// refill tops the bucket up for the time since it was last touched
+func (b *bucket) refill(now time.Time) {
+	elapsed := float64(now.Sub(b.updated).Milliseconds())
+	b.tokens = min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.perMs())
+	b.updated = now
+}
+
+// bucketSweepInterval is how often idle, full buckets are dropped
+const bucketSweepInterval = time.Minute
+
+// MemoryRateLimitStore keeps buckets in process memory, so each instance
+// counts on its own. Use RedisRateLimitStore to share limits across them.
+type MemoryRateLimitStore struct {
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	mux       sync.Mutex
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		s.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1-b.tokens)/limit.perMs()) * time.Millisecond
+	return false, wait, nil
+}
+
+// sweep drops buckets that have refilled completely; they'd be recreated
+// the same. Caller must hold the lock.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < bucketSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= b.limit.Per {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleHost     Role = "host"
+	RoleDJ       Role = "dj"
+	RoleListener Role = "listener"
+	RoleMuted    Role = "muted"
+)
+
+var roleRank = map[Role]int{
+	RoleMuted:    0,
+	RoleListener: 1,
+	RoleDJ:       2,
+	RoleHost:     3,
+}
+
+var (
+	errInvalidRole   = errors.New("invalid role")
+	errInvalidPolicy = errors.New("invalid room policy")
+)
+
+// atLeast reports whether r has every permission of min
+func (r Role) atLeast(min Role) bool {
+	return roleRank[r] >= roleRank[min]
+}
+
+// eventMinRole is the lowest role allowed to send each event. addToQueue is
+// governed by the room policy instead; events not listed are open to all.
+var eventMinRole = map[string]Role{
+	"next":            RoleDJ,
+	"previous":        RoleDJ,
+	"play":            RoleDJ,
+	"pause":           RoleDJ,
+	"seek":            RoleDJ,
+	"removeFromQueue": RoleDJ,
+	"moveInQueue":     RoleDJ,
+	"playNext":        RoleDJ,
+	"jumpTo":          RoleDJ,
+	"clearQueue":      RoleHost,
+	"transferHost":    RoleHost,
+	"setRole":         RoleHost,
+	"setPolicy":       RoleHost,
+	"setShuffle":      RoleHost,
+	"setRepeat":       RoleHost,
+	"voteSkip":        RoleListener,
+	"upvote":          RoleListener,
+	"downvote":        RoleListener,
+	"unvote":          RoleListener,
+	"chat":            RoleListener,
+	"reaction":        RoleListener,
+	"deleteMessage":   RoleHost,
+	"muteUser":        RoleHost,
+	"unmuteUser":      RoleHost,
+	"setAccess":       RoleHost,
+	"approveJoin":     RoleHost,
+	"denyJoin":        RoleHost,
+}
+
+// RoomPolicy holds the host's room-wide rules
+type RoomPolicy struct {
+	// AddSongRole is the lowest role that may add songs; empty means listener
+	AddSongRole Role `json:"addSongRole,omitempty"`
+	// SkipThreshold is the fraction of members whose votes skip a song;
+	// zero means half the room
+	SkipThreshold float64 `json:"skipThreshold,omitempty"`
+	// Queue limits what members can add
+	Queue QueuePolicy `json:"queue"`
+	// Autoplay adds related songs whenever the queue runs out
+	Autoplay bool `json:"autoplay,omitempty"`
+	// ManualAdvance stops the server moving on when a song ends
+	ManualAdvance bool `json:"manualAdvance,omitempty"`
+	// GapMs is silence left between songs; negative values start the next
+	// song early so clients can crossfade
+	GapMs int `json:"gapMs,omitempty"`
+}
+
+func (p RoomPolicy) addSongRole() Role {
+	if p.AddSongRole == "" {
+		return RoleListener
+	}
+	return p.AddSongRole
+}
+
+// roleOf returns a user's role: the host is always host, everyone else
+// defaults to listener until the host says otherwise
+func (s *RoomState) roleOf(userID string) Role {
+	if userID == s.HostID {
+		return RoleHost
+	}
+	if role, ok := s.Roles[userID]; ok {
+		return role
+	}
+	return RoleListener
+}
+
+// RoleOf returns the client's role in the room
+func (h *Hub) RoleOf(roomID string, c *Client) Role {
+	if c.User == nil {
+		return RoleMuted
+	}
+
+	state, err := h.loadState(roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
+		return RoleMuted
+	}
+
+	return state.roleOf(c.User.ID)
+}
+
+// Allowed reports whether the client may send an event of the given type
+func (h *Hub) Allowed(roomID string, c *Client, eventType string) bool {
+	if c.User == nil {
+		return false
+	}
+
+	state, err := h.loadState(roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
+		return false
+	}
+
+	role := state.roleOf(c.User.ID)
+	if eventType == "addToQueue" {
+		return role.atLeast(state.Policy.addSongRole())
+	}
+	if min, ok := eventMinRole[eventType]; ok {
+		return role.atLeast(min)
+	}
+	return true
+}
+
+// SetRole changes a member's role. Host can only move via TransferHost.
+func (h *Hub) SetRole(roomID, userID string, role Role) (user *User, status bool) {
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		if _, ok := roleRank[role]; !ok || role == RoleHost {
+			return errInvalidRole
+		}
+		if userID == state.HostID {
+			return errInvalidRole
+		}
+		member := state.memberByUserID(userID)
+		if member == nil {
+			return errNotRoomMember
+		}
+
+		if state.Roles == nil {
+			state.Roles = make(map[string]Role)
+		}
+		state.Roles[userID] = role
+		user = member.User
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to set role in room %s: %v", roomID, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// SetPolicy replaces the room policy
+func (h *Hub) SetPolicy(roomID string, policy RoomPolicy) (RoomPolicy, bool) {
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		if policy.AddSongRole != "" {
+			if _, ok := roleRank[policy.AddSongRole]; !ok {
+				return errInvalidRole
+			}
+		}
+		gap := time.Duration(policy.GapMs) * time.Millisecond
+		if gap < -maxCrossfade || gap > maxGap {
+			return errInvalidPolicy
+		}
+		if policy.SkipThreshold < 0 || policy.SkipThreshold > 1 || !policy.Queue.valid() {
+			return errInvalidPolicy
+		}
+		state.Policy = policy
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to set policy in room %s: %v", roomID, err)
+		return RoomPolicy{}, false
+	}
+	h.autoplay(roomID)
+
+	return policy, true
+}
+
+// Notify room when a member's role changes
+func (h *Hub) NotifyRoleChange(roomID string, user *User, role Role) {
+	event := Event{
+		Type: "role_changed",
+		User: user,
+		Role: role,
+	}
+	h.Broadcast(roomID, event)
+}
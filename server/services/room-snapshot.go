@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"log"
+)
+
+// RoomSnapshot is everything a late joiner needs to pick up mid-party
+type RoomSnapshot struct {
+	SongsQueue     []Song          `json:"songsQueue"`
+	CurrentSongIdx int             `json:"currentSongIdx"`
+	CurrentSong    *Song           `json:"currentSong"`
+	Host           *User           `json:"host"`
+	Playback       PlaybackState   `json:"playback"`
+	Roles          map[string]Role `json:"roles"`
+	Policy         RoomPolicy      `json:"policy"`
+	Mode           PlayMode        `json:"mode"`
+	// Seq is the last broadcast this snapshot already reflects
+	Seq int64 `json:"seq"`
+}
+
+func newRoomSnapshot(state *RoomState, seq int64) RoomSnapshot {
+	snapshot := RoomSnapshot{
+		SongsQueue:     state.SongsQueue,
+		CurrentSongIdx: state.CurrentSongIdx,
+		Playback:       state.Playback,
+		Roles:          state.Roles,
+		Policy:         state.Policy,
+		Mode:           state.Mode,
+		Seq:            seq,
+	}
+
+	if song, ok := state.currentSong(); ok {
+		snapshot.CurrentSong = &song
+	}
+	if host := state.hostMember(); host != nil {
+		snapshot.Host = host.User
+	}
+
+	return snapshot
+}
+
+// RoomSnapshot returns the room's queue, cursor, host and playback in one read
+func (h *Hub) RoomSnapshot(roomID string) (RoomSnapshot, bool) {
+	// Read the sequence number first: a broadcast racing the load is then
+	// replayed on top of a state that may already include it, rather than
+	// missed
+	seq, err := h.store.Seq(context.Background(), roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
+		return RoomSnapshot{}, false
+	}
+	state, err := h.loadState(roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
+		return RoomSnapshot{}, false
+	}
+
+	return newRoomSnapshot(state, seq), true
+}
+
+// SendRoomState sends the full room snapshot to a single client
+func (h *Hub) SendRoomState(c *Client) {
+	snapshot, ok := h.RoomSnapshot(c.Room)
+	if !ok {
+		return
+	}
+	h.Send(c, Event{
+		Type:       "room_state",
+		Room:       &snapshot,
+		ServerTime: nowMs(),
+	})
+}
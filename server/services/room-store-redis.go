@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxUpdateRetries bounds how often Update retries when another writer
+// touched the same room between our read and write.
+const maxUpdateRetries = 10
+
+var ErrRoomStoreBusy = errors.New("room state is being modified concurrently, try again")
+
+// publicRoomsKey is a set of the public rooms' IDs, so the lobby can be
+// listed without reading every room. Expired IDs are pruned by ListPublic.
+const publicRoomsKey = "vybe:rooms:public"
+
+// appendScript pushes onto a room's log and trims it, but only while the room
+// itself exists, and gives the log the room's remaining TTL
+var appendScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	return 0
+end
+redis.call("RPUSH", KEYS[2], ARGV[1])
+redis.call("LTRIM", KEYS[2], -tonumber(ARGV[2]), -1)
+redis.call("PEXPIRE", KEYS[2], ttl)
+return 1
+`)
+
+// nextSeqScript is appendScript for the room's sequence number
+var nextSeqScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	return 0
+end
+local seq = redis.call("INCR", KEYS[2])
+redis.call("PEXPIRE", KEYS[2], ttl)
+return seq
+`)
+
+// RedisRoomStore keeps each room as a JSON blob under "vybe:room:<id>",
+// its logs as lists under "vybe:room:<id>:<log>" and its sequence number
+// under "vybe:room:<id>:seq". Updates use WATCH/MULTI so concurrent writers
+// never lose each other's changes.
+type RedisRoomStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRedisRoomStore(client *redis.Client) *RedisRoomStore {
+	return &RedisRoomStore{
+		client: client,
+		ttl:    roomStateTTL,
+	}
+}
+
+func (s *RedisRoomStore) key(roomID string) string {
+	return fmt.Sprintf("vybe:room:%s", roomID)
+}
+
+func (s *RedisRoomStore) logKey(roomID string, name RoomLog) string {
+	return fmt.Sprintf("vybe:room:%s:%s", roomID, name)
+}
+
+func (s *RedisRoomStore) seqKey(roomID string) string {
+	return fmt.Sprintf("vybe:room:%s:seq", roomID)
+}
+
+func (s *RedisRoomStore) Load(ctx context.Context, roomID string) (*RoomState, error) {
+	return s.get(ctx, s.client, s.key(roomID))
+}
+
+func (s *RedisRoomStore) Update(ctx context.Context, roomID string, fn func(*RoomState) error) (*RoomState, error) {
+	key := s.key(roomID)
+
+	var state *RoomState
+	txf := func(tx *redis.Tx) error {
+		current, err := s.get(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if err := fn(current); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, s.ttl)
+			if current.Meta.Public {
+				pipe.SAdd(ctx, publicRoomsKey, roomID)
+			} else {
+				pipe.SRem(ctx, publicRoomsKey, roomID)
+			}
+			// Keep the logs alive as long as the room
+			pipe.Expire(ctx, s.seqKey(roomID), s.ttl)
+			for _, name := range roomLogs {
+				pipe.Expire(ctx, s.logKey(roomID, name), s.ttl)
+			}
+			return nil
+		})
+		if err == nil {
+			state = current
+		}
+		return err
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+
+	return nil, ErrRoomStoreBusy
+}
+
+func (s *RedisRoomStore) Delete(ctx context.Context, roomID string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.key(roomID), s.seqKey(roomID))
+		for _, name := range roomLogs {
+			pipe.Del(ctx, s.logKey(roomID, name))
+		}
+		pipe.SRem(ctx, publicRoomsKey, roomID)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisRoomStore) ListPublic(ctx context.Context) ([]string, error) {
+	roomIDs, err := s.client.SMembers(ctx, publicRoomsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make([]*redis.IntCmd, len(roomIDs))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, roomID := range roomIDs {
+			exists[i] = pipe.Exists(ctx, s.key(roomID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	live := make([]string, 0, len(roomIDs))
+	for i, roomID := range roomIDs {
+		if exists[i].Val() == 0 {
+			// The state expired; drop it from the index too
+			s.client.SRem(ctx, publicRoomsKey, roomID)
+			continue
+		}
+		live = append(live, roomID)
+	}
+	return live, nil
+}
+
+func (s *RedisRoomStore) Seq(ctx context.Context, roomID string) (int64, error) {
+	seq, err := s.client.Get(ctx, s.seqKey(roomID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return seq, err
+}
+
+func (s *RedisRoomStore) NextSeq(ctx context.Context, roomID string) (int64, error) {
+	seq, err := nextSeqScript.Run(ctx, s.client, []string{s.key(roomID), s.seqKey(roomID)}).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if seq == 0 {
+		return 0, ErrRoomNotFound
+	}
+	return seq, nil
+}
+
+func (s *RedisRoomStore) Append(ctx context.Context, roomID string, name RoomLog, entry []byte, max int) error {
+	ok, err := appendScript.Run(ctx, s.client, []string{s.key(roomID), s.logKey(roomID, name)}, entry, max).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrRoomNotFound
+	}
+	return nil
+}
+
+func (s *RedisRoomStore) Entries(ctx context.Context, roomID string, name RoomLog) ([][]byte, error) {
+	entries, err := s.client.LRange(ctx, s.logKey(roomID, name), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	data := make([][]byte, len(entries))
+	for i, entry := range entries {
+		data[i] = []byte(entry)
+	}
+	return data, nil
+}
+
+func (s *RedisRoomStore) Remove(ctx context.Context, roomID string, name RoomLog, entry []byte) (bool, error) {
+	n, err := s.client.LRem(ctx, s.logKey(roomID, name), 1, entry).Result()
+	return n > 0, err
+}
+
+func (s *RedisRoomStore) get(ctx context.Context, cmd redis.Cmdable, key string) (*RoomState, error) {
+	data, err := cmd.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return newRoomState(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoomState(data)
+}
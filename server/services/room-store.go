@@ -0,0 +1,289 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// roomStateTTL is how long an idle room's state is kept around after the
+// last write, so a host reconnecting after a deploy finds the queue intact.
+const roomStateTTL = 24 * time.Hour
+
+// Member is an authenticated connection that is part of a room.
+type Member struct {
+	ClientID  string    `json:"clientId"`
+	User      *User     `json:"user"`
+	JoinedAt  time.Time `json:"joinedAt"`
+	SessionID string    `json:"sessionId"`
+	// DisconnectedAt is set while the member's connection is gone and they
+	// can still resume it
+	DisconnectedAt *time.Time `json:"disconnectedAt,omitempty"`
+}
+
+// RoomState is the durable part of a room. Connections stay in memory on the
+// Hub, everything else lives here. HostID is the owning user's ID rather than
+// a connection, so every tab of the host's account can drive the room.
+type RoomState struct {
+	Meta           RoomMeta        `json:"meta"`
+	Access         RoomAccess      `json:"access"`
+	SongsQueue     []Song          `json:"songsQueue"`
+	CurrentSongIdx int             `json:"currentSongIdx"`
+	Members        []*Member       `json:"members"`
+	HostID         string          `json:"hostId"`
+	Roles          map[string]Role `json:"roles"`
+	Policy         RoomPolicy      `json:"policy"`
+	Mode           PlayMode        `json:"mode"`
+	Playback       PlaybackState   `json:"playback"`
+	// SkipVotes holds the user IDs voting to skip the current song
+	SkipVotes []string `json:"skipVotes"`
+	// Unshuffled holds the AddedAt of the songs to come, in the order they
+	// were in when shuffle was turned on, so turning it off can restore it
+	Unshuffled []int64 `json:"unshuffled,omitempty"`
+	// NowPlaying is the history record of the song playing; finished plays
+	// go to the room's history log
+	NowPlaying *PlayRecord `json:"nowPlaying,omitempty"`
+
+	// ended collects the plays an update finished, for updateState to log
+	ended []PlayRecord
+}
+
+func newRoomState() *RoomState {
+	return &RoomState{
+		SongsQueue:     []Song{},
+		CurrentSongIdx: -1,
+		Members:        []*Member{},
+		Roles:          make(map[string]Role),
+	}
+}
+
+func (s *RoomState) currentSong() (Song, bool) {
+	if s.CurrentSongIdx < 0 || s.CurrentSongIdx >= len(s.SongsQueue) {
+		return Song{}, false
+	}
+	return s.SongsQueue[s.CurrentSongIdx], true
+}
+
+// RoomLog names one of a room's append-only logs. They are kept apart from
+// RoomState so that chatting, broadcasting and playing songs don't rewrite
+// (and every read doesn't decode) the whole room.
+type RoomLog string
+
+const (
+	LogEvents  RoomLog = "events"
+	LogChat    RoomLog = "chat"
+	LogHistory RoomLog = "history"
+)
+
+var roomLogs = []RoomLog{LogEvents, LogChat, LogHistory}
+
+// RoomStore persists RoomState. Update runs fn against the latest state and
+// saves the result atomically; if fn returns an error nothing is written.
+// A room's logs and sequence number live and die with its state.
+type RoomStore interface {
+	Load(ctx context.Context, roomID string) (*RoomState, error)
+	Update(ctx context.Context, roomID string, fn func(*RoomState) error) (*RoomState, error)
+	Delete(ctx context.Context, roomID string) error
+	// ListPublic returns the IDs of the public rooms with stored state, from
+	// an index kept up to date by Update rather than by reading every room
+	ListPublic(ctx context.Context) ([]string, error)
+
+	// Seq returns the room's last broadcast sequence number. NextSeq takes
+	// the next one, or fails with ErrRoomNotFound when the room has no state.
+	Seq(ctx context.Context, roomID string) (int64, error)
+	NextSeq(ctx context.Context, roomID string) (int64, error)
+	// Append adds entry to the end of a log, keeping only the newest max
+	Append(ctx context.Context, roomID string, name RoomLog, entry []byte, max int) error
+	// Entries returns a log, oldest first
+	Entries(ctx context.Context, roomID string, name RoomLog) ([][]byte, error)
+	// Remove drops an entry from a log; it reports false if it wasn't there
+	Remove(ctx context.Context, roomID string, name RoomLog, entry []byte) (bool, error)
+}
+
+// ---- In-memory store ----
+
+type memoryEntry struct {
+	data      []byte
+	seq       int64
+	logs      map[RoomLog][][]byte
+	public    bool
+	expiresAt time.Time
+}
+
+// MemoryRoomStore keeps room state in process memory. Useful for local
+// development without Redis; state does not survive a restart. Entries are
+// stored encoded, same as in Redis, so callers never share slices with it.
+type MemoryRoomStore struct {
+	rooms map[string]*memoryEntry
+	ttl   time.Duration
+	mux   sync.Mutex
+}
+
+func NewMemoryRoomStore() *MemoryRoomStore {
+	return &MemoryRoomStore{
+		rooms: make(map[string]*memoryEntry),
+		ttl:   roomStateTTL,
+	}
+}
+
+func (s *MemoryRoomStore) Load(ctx context.Context, roomID string) (*RoomState, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.get(roomID)
+}
+
+func (s *MemoryRoomStore) Update(ctx context.Context, roomID string, fn func(*RoomState) error) (*RoomState, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	state, err := s.get(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(state); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := s.rooms[roomID]
+	if !ok {
+		entry = &memoryEntry{logs: make(map[RoomLog][][]byte)}
+		s.rooms[roomID] = entry
+	}
+	entry.data = data
+	entry.public = state.Meta.Public
+	entry.expiresAt = time.Now().Add(s.ttl)
+	return state, nil
+}
+
+func (s *MemoryRoomStore) Delete(ctx context.Context, roomID string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.rooms, roomID)
+	return nil
+}
+
+func (s *MemoryRoomStore) ListPublic(ctx context.Context) ([]string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.sweep()
+	roomIDs := []string{}
+	for roomID, entry := range s.rooms {
+		if entry.public {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	return roomIDs, nil
+}
+
+func (s *MemoryRoomStore) Seq(ctx context.Context, roomID string) (int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.sweep()
+	if entry, ok := s.rooms[roomID]; ok {
+		return entry.seq, nil
+	}
+	return 0, nil
+}
+
+func (s *MemoryRoomStore) NextSeq(ctx context.Context, roomID string) (int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.sweep()
+	entry, ok := s.rooms[roomID]
+	if !ok {
+		return 0, ErrRoomNotFound
+	}
+	entry.seq++
+	return entry.seq, nil
+}
+
+func (s *MemoryRoomStore) Append(ctx context.Context, roomID string, name RoomLog, entry []byte, max int) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.sweep()
+	room, ok := s.rooms[roomID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	entries := append(room.logs[name], slices.Clone(entry))
+	if len(entries) > max {
+		entries = slices.Clone(entries[len(entries)-max:])
+	}
+	room.logs[name] = entries
+	return nil
+}
+
+func (s *MemoryRoomStore) Entries(ctx context.Context, roomID string, name RoomLog) ([][]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.sweep()
+	room, ok := s.rooms[roomID]
+	if !ok {
+		return nil, nil
+	}
+	return slices.Clone(room.logs[name]), nil
+}
+
+func (s *MemoryRoomStore) Remove(ctx context.Context, roomID string, name RoomLog, entry []byte) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.sweep()
+	room, ok := s.rooms[roomID]
+	if !ok {
+		return false, nil
+	}
+	i := slices.IndexFunc(room.logs[name], func(e []byte) bool {
+		return bytes.Equal(e, entry)
+	})
+	if i < 0 {
+		return false, nil
+	}
+	room.logs[name] = slices.Delete(room.logs[name], i, i+1)
+	return true, nil
+}
+
+// sweep drops expired entries. Caller must hold s.mux.
+func (s *MemoryRoomStore) sweep() {
+	now := time.Now()
+	for id, entry := range s.rooms {
+		if now.After(entry.expiresAt) {
+			delete(s.rooms, id)
+		}
+	}
+}
+
+// get decodes the stored state or returns a fresh one, dropping expired
+// entries on the way. Caller must hold s.mux.
+func (s *MemoryRoomStore) get(roomID string) (*RoomState, error) {
+	s.sweep()
+
+	entry, ok := s.rooms[roomID]
+	if !ok {
+		return newRoomState(), nil
+	}
+	return decodeRoomState(entry.data)
+}
+
+func decodeRoomState(data []byte) (*RoomState, error) {
+	state := newRoomState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("corrupt room state: %w", err)
+	}
+	return state, nil
+}
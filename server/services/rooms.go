@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Join codes skip look-alike characters (0/O, 1/I/L) so they survive
+	// being read out loud
+	joinCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	joinCodeLength   = 6
+	maxRoomNameLen   = 60
+	// maxRoomIDLen bounds IDs of rooms opened implicitly over the socket
+	maxRoomIDLen = 64
+	// createRoomAttempts bounds retries when a generated code is taken
+	createRoomAttempts = 5
+)
+
+var (
+	ErrRoomNotFound    = errors.New("room not found")
+	ErrRoomExists      = errors.New("room already exists")
+	ErrNotRoomHost     = errors.New("only the host can do that")
+	ErrInvalidRoomName = errors.New("room name must be 1-60 characters")
+	// ErrRoomPrivate hides a locked room's queue and history from people
+	// who haven't been let in
+	ErrRoomPrivate = errors.New("only people in the room can see that")
+)
+
+// ValidRoomID reports whether id can name a room: letters, digits, '-' and
+// '_' only. A ':' would let one room's key in the store name another room's
+// log or sequence number.
+func ValidRoomID(id string) bool {
+	if id == "" || len(id) > maxRoomIDLen {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// RoomMeta is what a room looks like from the lobby. Rooms opened implicitly
+// over the socket have an empty meta and are never listed.
+type RoomMeta struct {
+	Name      string `json:"name"`
+	Public    bool   `json:"public"`
+	CreatedBy *User  `json:"createdBy,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// publicUser is the part of a user anyone may see, i.e. everything but their
+// email. Room data served over HTTP only ever carries users this way.
+func publicUser(u *User) *User {
+	if u == nil {
+		return nil
+	}
+	return &User{ID: u.ID, Name: u.Name, Picture: u.Picture}
+}
+
+func publicSong(song Song) Song {
+	song.RequestedBy = publicUser(song.RequestedBy)
+	return song
+}
+
+func publicSongs(songs []Song) []Song {
+	public := make([]Song, len(songs))
+	for i, song := range songs {
+		public[i] = publicSong(song)
+	}
+	return public
+}
+
+// canView reports whether user may see the room's queue and history. Open
+// rooms show them to anyone; locked ones only to people they'd let in
+// without asking.
+func (s *RoomState) canView(user *User) bool {
+	if s.Access.PasswordHash == "" && !s.Access.Knock {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+	return s.HostID == user.ID ||
+		(s.Meta.CreatedBy != nil && s.Meta.CreatedBy.ID == user.ID) ||
+		slices.Contains(s.Access.Admitted, user.ID) ||
+		s.memberByUserID(user.ID) != nil
+}
+
+// RoomInfo is a room's public metadata
+type RoomInfo struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Public      bool   `json:"public"`
+	Host        *User  `json:"host"`
+	MemberCount int    `json:"memberCount"`
+	NowPlaying  *Song  `json:"nowPlaying"`
+	CreatedAt   int64  `json:"createdAt"`
+}
+
+// inUse reports whether anything has ever happened in this room
+func (s *RoomState) inUse() bool {
+	return s.Meta.CreatedAt != 0 || len(s.Members) > 0 || len(s.SongsQueue) > 0
+}
+
+// memberCount counts distinct users, not connections
+func (s *RoomState) memberCount() int {
+	users := map[string]bool{}
+	for _, m := range s.Members {
+		if m.User != nil {
+			users[m.User.ID] = true
+		}
+	}
+	return len(users)
+}
+
+// newRoomInfo describes the room to viewer. What is playing, and who asked
+// for it, is left out for viewers the room wouldn't show its queue to.
+func newRoomInfo(roomID string, state *RoomState, viewer *User) RoomInfo {
+	info := RoomInfo{
+		ID:          roomID,
+		Name:        state.Meta.Name,
+		Public:      state.Meta.Public,
+		MemberCount: state.memberCount(),
+		CreatedAt:   state.Meta.CreatedAt,
+	}
+	if info.Name == "" {
+		info.Name = roomID
+	}
+	if host := state.hostMember(); host != nil {
+		info.Host = publicUser(host.User)
+	}
+	if song, ok := state.currentSong(); ok && state.canView(viewer) {
+		song = publicSong(song)
+		info.NowPlaying = &song
+	}
+	return info
+}
+
+func newJoinCode() string {
+	buf := make([]byte, joinCodeLength)
+	rand.Read(buf)
+	for i, b := range buf {
+		buf[i] = joinCodeAlphabet[int(b)%len(joinCodeAlphabet)]
+	}
+	return string(buf)
+}
+
+// CreateRoom opens a new room owned by creator. The returned ID is a short
+// join code that doubles as the /ws/:roomID path segment. An empty password
+// leaves the room open.
+func (h *Hub) CreateRoom(creator *User, name string, public bool, password string, knock bool) (RoomInfo, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxRoomNameLen {
+		return RoomInfo{}, ErrInvalidRoomName
+	}
+
+	hashed := newRoomPassword(password)
+	for i := 0; i < createRoomAttempts; i++ {
+		roomID := newJoinCode()
+		state, err := h.updateState(roomID, func(state *RoomState) error {
+			if state.inUse() {
+				return ErrRoomExists
+			}
+			state.Meta = RoomMeta{
+				Name:      name,
+				Public:    public,
+				CreatedBy: creator,
+				CreatedAt: nowMs(),
+			}
+			state.HostID = creator.ID
+			state.Access.setPassword(hashed)
+			state.Access.Knock = knock
+			return nil
+		})
+		if errors.Is(err, ErrRoomExists) {
+			continue
+		}
+		if err != nil {
+			return RoomInfo{}, err
+		}
+		return newRoomInfo(roomID, state, creator), nil
+	}
+
+	return RoomInfo{}, ErrRoomExists
+}
+
+// GetRoomInfo returns a room's public metadata as user may see it
+func (h *Hub) GetRoomInfo(roomID string, user *User) (RoomInfo, error) {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		return RoomInfo{}, err
+	}
+	if !state.inUse() {
+		return RoomInfo{}, ErrRoomNotFound
+	}
+
+	return newRoomInfo(roomID, state, user), nil
+}
+
+// ListPublicRooms returns every explicitly created public room, as user
+// may see them
+func (h *Hub) ListPublicRooms(user *User) ([]RoomInfo, error) {
+	roomIDs, err := h.store.ListPublic(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := []RoomInfo{}
+	for _, roomID := range roomIDs {
+		state, err := h.loadState(roomID)
+		if err != nil {
+			log.Printf("Failed to load room %s: %v", roomID, err)
+			continue
+		}
+		if state.Meta.Public {
+			rooms = append(rooms, newRoomInfo(roomID, state, user))
+		}
+	}
+
+	return rooms, nil
+}
+
+// CloseRoom ends a room for everyone: clients are told, disconnected on every
+// instance, and the stored state is dropped
+func (h *Hub) CloseRoom(roomID string, by *User) error {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		return err
+	}
+	if !state.inUse() {
+		return ErrRoomNotFound
+	}
+	if state.HostID != by.ID {
+		return ErrNotRoomHost
+	}
+
+	if err := h.store.Delete(context.Background(), roomID); err != nil {
+		return err
+	}
+
+	h.publish(roomID, Event{Type: "room_closed"}, busMessage{Close: true})
+	return nil
+}
+
+// disconnectRoom hangs up every local connection of a room once their queued
+// frames are flushed. Their read loops then run the usual LeaveRoom cleanup.
+func (h *Hub) disconnectRoom(roomID string) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	room, ok := h.rooms[roomID]
+	if !ok {
+		return
+	}
+	for client := range room.clients {
+		client.stop()
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidRoomID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"ABC123", true},
+		{"my-room_2", true},
+		{strings.Repeat("a", maxRoomIDLen), true},
+		{"", false},
+		{strings.Repeat("a", maxRoomIDLen+1), false},
+		{"ABC123:seq", false},
+		{"ABC123:events", false},
+		{"ABC123%3Aseq", false},
+		{"a b", false},
+		{"room/1", false},
+		{"ünï", false},
+	}
+	for _, tt := range tests {
+		if got := ValidRoomID(tt.id); got != tt.want {
+			t.Errorf("ValidRoomID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestRoomInfoHidesNowPlayingInLockedRooms(t *testing.T) {
+	hub := newTestHub(t)
+	host := &User{ID: "host", Name: "host", Email: "host@example.com"}
+	room, err := hub.CreateRoom(host, "Locked", true, "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.store.Update(context.Background(), room.ID, func(s *RoomState) error {
+		s.SongsQueue = []Song{{Title: "A", VideoID: "a", RequestedBy: host}}
+		s.CurrentSongIdx = 0
+		return nil
+	})
+
+	for _, viewer := range []*User{nil, {ID: "outsider"}} {
+		info, err := hub.GetRoomInfo(room.ID, viewer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.NowPlaying != nil {
+			t.Errorf("viewer %+v sees now playing %+v", viewer, info.NowPlaying)
+		}
+		rooms, err := hub.ListPublicRooms(viewer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rooms) != 1 || rooms[0].NowPlaying != nil {
+			t.Errorf("viewer %+v sees lobby %+v", viewer, rooms)
+		}
+	}
+
+	info, err := hub.GetRoomInfo(room.ID, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NowPlaying == nil || info.NowPlaying.Title != "A" {
+		t.Fatalf("host sees now playing %+v", info.NowPlaying)
+	}
+	if info.NowPlaying.RequestedBy.Email != "" {
+		t.Fatalf("now playing leaks the requester's email")
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+// reconnectGrace is how long a dropped connection keeps its membership.
+// Resuming the session within it is seamless: nobody sees a leave or join.
+const reconnectGrace = 30 * time.Second
+
+var errUnknownSession = errors.New("no such session in this room")
+
+func (s *RoomState) memberBySession(session string) *Member {
+	for _, m := range s.Members {
+		if m.SessionID == session {
+			return m
+		}
+	}
+	return nil
+}
+
+func (m *Member) expired(now time.Time) bool {
+	return m.DisconnectedAt != nil && now.Sub(*m.DisconnectedAt) >= reconnectGrace
+}
+
+// ResumeSession hands a dropped (or dying) membership over to a new
+// connection of the same user. It fails once the grace period is over.
+func (h *Hub) ResumeSession(roomID string, c *Client, session string) (isHost bool, ok bool) {
+	var oldClientID string
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		m := state.memberBySession(session)
+		if m == nil || m.User == nil || m.User.ID != c.User.ID || m.expired(time.Now()) {
+			return errUnknownSession
+		}
+		oldClientID = m.ClientID
+		m.ClientID = c.ID
+		m.DisconnectedAt = nil
+		isHost = state.HostID == c.User.ID
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errUnknownSession) {
+			log.Printf("Failed to resume session in room %s: %v", roomID, err)
+		}
+		return false, false
+	}
+
+	c.Session = session
+	// The old connection may not have noticed it's dead yet
+	h.stopClient(roomID, oldClientID)
+	return isHost, true
+}
+
+func (h *Hub) stopClient(roomID, clientID string) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	room, ok := h.rooms[roomID]
+	if !ok {
+		return
+	}
+	for client := range room.clients {
+		if client.ID == clientID {
+			client.stop()
+		}
+	}
+}
+
+// markDisconnected starts a member's grace period
+func (h *Hub) markDisconnected(roomID string, c *Client) {
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		i := slices.IndexFunc(state.Members, func(m *Member) bool {
+			return m.ClientID == c.ID
+		})
+		if i < 0 {
+			// Resumed elsewhere already, or the room was closed
+			return errNotRoomMember
+		}
+		now := time.Now()
+		state.Members[i].DisconnectedAt = &now
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errNotRoomMember) {
+			log.Printf("Failed to mark member disconnected in room %s: %v", roomID, err)
+		}
+		return
+	}
+
+	time.AfterFunc(reconnectGrace, func() {
+		select {
+		case <-h.done:
+		default:
+			h.expireMembers(roomID)
+		}
+	})
+}
+
+// expireMembers removes members whose grace period ran out
+func (h *Hub) expireMembers(roomID string) {
+	now := time.Now()
+	h.removeMembers(roomID, func(m *Member) bool {
+		return m.expired(now)
+	})
+}
+
+// removeMembers drops matching members for good, hands the room on if the
+// host was among them and has no other connection, and tells everyone who
+// left
+func (h *Hub) removeMembers(roomID string, match func(*Member) bool) {
+	var removed []*Member
+	var newHost *User
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		removed, newHost = nil, nil
+		state.Members = slices.DeleteFunc(state.Members, func(m *Member) bool {
+			if match(m) {
+				removed = append(removed, m)
+				return true
+			}
+			return false
+		})
+		if len(removed) == 0 {
+			// Already gone, e.g. the room was closed; nothing to write
+			return errNotRoomMember
+		}
+		// Only a host who was here and has now gone is replaced; a creator
+		// who hasn't connected yet keeps the room
+		hostLeft := slices.ContainsFunc(removed, func(m *Member) bool {
+			return m.User != nil && m.User.ID == state.HostID
+		})
+		if hostLeft && state.hostMember() == nil {
+			newHost = state.promoteHost()
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errNotRoomMember) {
+			log.Printf("Failed to remove members from room %s: %v", roomID, err)
+		}
+		return
+	}
+
+	for _, m := range removed {
+		h.NotifyUserLeave(roomID, m.User)
+	}
+	if newHost != nil {
+		h.NotifyHostChange(roomID, newHost)
+	}
+}
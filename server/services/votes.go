@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"math"
+	"slices"
+)
+
+// defaultSkipThreshold is the fraction of members that must vote to skip
+// when the room policy doesn't say otherwise
+const defaultSkipThreshold = 0.5
+
+var (
+	errAlreadyVoted = errors.New("already voted to skip this song")
+	errInvalidVote  = errors.New("vote must be -1, 0 or 1")
+	errNotUpcoming  = errors.New("only upcoming songs can be voted on")
+)
+
+// SkipTally is the vote-to-skip count for the current song
+type SkipTally struct {
+	Votes  int `json:"votes"`
+	Needed int `json:"needed"`
+}
+
+func (p RoomPolicy) skipThreshold() float64 {
+	if p.SkipThreshold <= 0 {
+		return defaultSkipThreshold
+	}
+	return p.SkipThreshold
+}
+
+// skipTally counts votes from users still in the room against the threshold
+func (s *RoomState) skipTally() SkipTally {
+	users := map[string]bool{}
+	for _, m := range s.Members {
+		if m.User != nil {
+			users[m.User.ID] = true
+		}
+	}
+
+	votes := 0
+	for _, userID := range s.SkipVotes {
+		if users[userID] {
+			votes++
+		}
+	}
+
+	needed := int(math.Ceil(s.Policy.skipThreshold() * float64(len(users))))
+	return SkipTally{Votes: votes, Needed: max(needed, 1)}
+}
+
+// VoteSkip records the client's vote against the current song and moves to
+// the next one once enough members agree. skipped is false while the vote is
+// still open (or when there is nothing to skip to).
+func (h *Hub) VoteSkip(roomID string, c *Client) (tally SkipTally, nextSong Song, skipped bool) {
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		skipped = false
+		if slices.Contains(state.SkipVotes, c.User.ID) {
+			return errAlreadyVoted
+		}
+		state.SkipVotes = append(state.SkipVotes, c.User.ID)
+
+		tally = state.skipTally()
+		if tally.Votes < tally.Needed {
+			return nil
+		}
+
+		if _, ok := state.nextIndex(false); ok {
+			state.endPlay(nowMs(), PlaySkipped)
+		}
+		song, err := state.advance(false)
+		if err != nil {
+			// Keep the votes; they count as soon as something is queued
+			return nil
+		}
+		nextSong, skipped = song, true
+		return nil
+	})
+	h.autoplay(roomID)
+	if err != nil {
+		log.Printf("Failed to vote skip in room %s: %v", roomID, err)
+		return SkipTally{}, Song{}, false
+	}
+
+	return tally, nextSong, skipped
+}
+
+// VoteSong sets the client's vote (+1, -1, or 0 to clear) on an upcoming song
+// and re-sorts everything after the current song by score. Ties keep their
+// existing order.
+func (h *Hub) VoteSong(roomID string, c *Client, index, vote int) (queue []Song, currentIdx int, status bool) {
+	return h.editQueue(roomID, "vote on song", func(state *RoomState) error {
+		if vote < -1 || vote > 1 {
+			return errInvalidVote
+		}
+		if index < 0 || index >= len(state.SongsQueue) {
+			return errInvalidIndex
+		}
+		if index <= state.CurrentSongIdx {
+			return errNotUpcoming
+		}
+
+		song := &state.SongsQueue[index]
+		if vote == 0 {
+			delete(song.Votes, c.User.ID)
+		} else {
+			if song.Votes == nil {
+				song.Votes = make(map[string]int)
+			}
+			song.Votes[c.User.ID] = vote
+		}
+
+		upcoming := state.SongsQueue[state.CurrentSongIdx+1:]
+		slices.SortStableFunc(upcoming, func(a, b Song) int {
+			return b.score() - a.score()
+		})
+		return nil
+	})
+}
+
+// score is the sum of a song's up and down votes
+func (s Song) score() int {
+	total := 0
+	for _, v := range s.Votes {
+		total += v
+	}
+	return total
+}
+
+// Broadcast the skip vote count for the current song
+func (h *Hub) BroadcastSkipTally(roomID string, tally SkipTally) {
+	event := Event{
+		Type:      "skip_votes",
+		SkipVotes: &tally,
+	}
+	h.Broadcast(roomID, event)
+}
@@ -1,48 +1,169 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
 	"sync"
+	"time"
 
-	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
 )
 
-type Client struct {
-	Conn   *websocket.Conn
-	Room   string
-	IsHost bool
-	User   *User
-}
-
 type Song struct {
-	Title   string
-	VideoID string
+	Title     string
+	VideoID   string
+	Artists   []string `json:",omitempty"`
+	Thumbnail string   `json:",omitempty"`
+	// Duration is "m:ss" or "h:mm:ss". The hub looks it up itself and ignores
+	// what the client sent; empty means unknown.
+	Duration string `json:",omitempty"`
+	// RequestedBy and AddedAt (unix ms) are stamped by the hub when the song
+	// is queued; whatever the client sent is ignored
+	RequestedBy *User `json:",omitempty"`
+	AddedAt     int64 `json:",omitempty"`
+	// AutoAdded marks songs autoplay picked rather than a member
+	AutoAdded bool `json:",omitempty"`
+	// Votes maps user ID to +1/-1 while the song is waiting in the queue
+	Votes map[string]int `json:",omitempty"`
 }
 
 type Event struct {
-	Type  string  `json:"type"`
-	Song  Song    `json:"song,omitempty"`
-	Token string  `json:"token,omitempty"`
-	Users []*User `json:"users,omitempty"`
-	User  *User   `json:"user,omitempty"`
+	Type       string         `json:"-"`
+	Song       *Song          `json:"song,omitempty"`
+	Users      []*User        `json:"users,omitempty"`
+	User       *User          `json:"user,omitempty"`
+	Playback   *PlaybackState `json:"playback,omitempty"`
+	ServerTime int64          `json:"serverTime,omitempty"`
+	Room       *RoomSnapshot  `json:"room,omitempty"`
+	// Queue is omitted when empty; CurrentSongIdx is always set alongside it
+	Queue          []Song        `json:"queue,omitempty"`
+	CurrentSongIdx *int          `json:"currentSongIdx,omitempty"`
+	Index          *int          `json:"index,omitempty"`
+	Role           Role          `json:"role,omitempty"`
+	Policy         *RoomPolicy   `json:"policy,omitempty"`
+	Mode           *PlayMode     `json:"mode,omitempty"`
+	SkipVotes      *SkipTally    `json:"skipVotes,omitempty"`
+	Text           string        `json:"text,omitempty"`
+	Emoji          string        `json:"emoji,omitempty"`
+	MessageID      string        `json:"messageId,omitempty"`
+	Message        *ChatMessage  `json:"message,omitempty"`
+	Messages       []ChatMessage `json:"messages,omitempty"`
+	// Seq numbers broadcasts per room so a reconnecting client can ask for
+	// what it missed; it travels on the envelope
+	Seq int64 `json:"-"`
 }
 
+// Room holds the connections this process owns for a room. Queue and
+// membership live in the RoomStore so they survive restarts. Pending
+// connections are knocking and wait on their channel for the host's answer.
 type Room struct {
-	clients        map[*Client]bool
-	songsQueue     []Song
-	currentSongIdx int
+	clients map[*Client]bool
+	pending map[*Client]chan bool
+}
+
+func newRoom() *Room {
+	return &Room{
+		clients: make(map[*Client]bool),
+		pending: make(map[*Client]chan bool),
+	}
 }
 
 type Hub struct {
-	rooms map[string]*Room
-	mux   sync.RWMutex
+	rooms       map[string]*Room
+	store       RoomStore
+	broker      Broker
+	recommender Recommender
+	durations   DurationResolver
+	// autoplaying marks rooms with an autoplay top-up in flight
+	autoplaying map[string]bool
+	// advanceTimers end each playing song; see scheduleAdvance
+	advanceTimers map[string]*time.Timer
+	timersMux     sync.Mutex
+	done          chan struct{}
+	mux           sync.RWMutex
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		rooms: make(map[string]*Room),
+var (
+	errEndOfQueue   = errors.New("end of queue")
+	errStartOfQueue = errors.New("start of queue")
+)
+
+func NewHub(store RoomStore, broker Broker) (*Hub, error) {
+	h := &Hub{
+		rooms:         make(map[string]*Room),
+		store:         store,
+		broker:        broker,
+		recommender:   SearchRecommender{},
+		durations:     YtDlpDurations{},
+		autoplaying:   make(map[string]bool),
+		advanceTimers: make(map[string]*time.Timer),
+		done:          make(chan struct{}),
+	}
+
+	if err := broker.Subscribe(h.deliver); err != nil {
+		return nil, err
 	}
+
+	go h.runSyncLoop()
+	go h.runReaper()
+	return h, nil
+}
+
+// Close stops the background loops and receiving events from other instances
+func (h *Hub) Close() error {
+	close(h.done)
+	h.stopAdvanceTimers()
+	return h.broker.Close()
+}
+
+func (h *Hub) loadState(roomID string) (*RoomState, error) {
+	return h.store.Load(context.Background(), roomID)
+}
+
+// updateState applies fn to the stored room, logs the plays it finished and
+// re-times auto-advance for whatever it left playing
+func (h *Hub) updateState(roomID string, fn func(*RoomState) error) (*RoomState, error) {
+	state, err := h.store.Update(context.Background(), roomID, fn)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range state.ended {
+		if err := h.appendLog(roomID, LogHistory, rec, historySize); err != nil {
+			log.Printf("Failed to record play in room %s: %v", roomID, err)
+		}
+	}
+	h.scheduleAdvance(roomID, state)
+	return state, nil
+}
+
+// appendLog encodes v onto one of the room's logs
+func (h *Hub) appendLog(roomID string, name RoomLog, v any, max int) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return h.store.Append(context.Background(), roomID, name, data, max)
+}
+
+// readLog decodes one of a room's logs, oldest first
+func readLog[T any](h *Hub, roomID string, name RoomLog) ([]T, error) {
+	entries, err := h.store.Entries(context.Background(), roomID, name)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]T, 0, len(entries))
+	for _, entry := range entries {
+		var item T
+		if err := json.Unmarshal(entry, &item); err != nil {
+			return nil, fmt.Errorf("corrupt %s log: %w", name, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
 }
 
 func (h *Hub) JoinRoom(roomID string, c *Client) {
@@ -51,30 +172,166 @@ func (h *Hub) JoinRoom(roomID string, c *Client) {
 
 	room, ok := h.rooms[roomID]
 	if !ok {
-		room = &Room{
-			clients:        make(map[*Client]bool),
-			songsQueue:     []Song{},
-			currentSongIdx: -1,
-		}
+		room = newRoom()
 		h.rooms[roomID] = room
 	}
 
 	room.clients[c] = true
 }
 
-func (h *Hub) LeaveRoom(roomID string, c *Client) {
+// LeaveRoom drops a connection from the room. Someone who left on purpose is
+// gone straight away; a dropped connection keeps its membership for
+// reconnectGrace so the user can resume where they were.
+func (h *Hub) LeaveRoom(roomID string, c *Client, left bool) {
 	h.mux.Lock()
-	defer h.mux.Unlock()
-
 	if room, ok := h.rooms[roomID]; ok {
 		delete(room.clients, c)
-		if len(room.clients) == 0 {
+		if len(room.clients) == 0 && len(room.pending) == 0 {
 			delete(h.rooms, roomID)
 		}
 	}
+	h.mux.Unlock()
+
+	if c.User == nil {
+		return
+	}
+
+	// The room state itself is kept (with a TTL) so the queue is still
+	// there when someone reconnects.
+	if left {
+		h.removeMembers(roomID, func(m *Member) bool {
+			return m.ClientID == c.ID
+		})
+		return
+	}
+	h.markDisconnected(roomID, c)
+}
+
+// AddMember records an authenticated client in the room's durable member
+// list and gives it a session it can resume. A client asking to host claims
+// the room if nobody owns it yet.
+func (h *Hub) AddMember(roomID string, c *Client) (isHost bool) {
+	session := uuid.NewString()
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		if c.WantsHost && state.HostID == "" {
+			state.HostID = c.User.ID
+		}
+		isHost = state.HostID == c.User.ID
+
+		for _, m := range state.Members {
+			if m.ClientID == c.ID {
+				return nil
+			}
+		}
+		// The user is back without their old session; its grace period
+		// would only end in a stray user_left
+		state.Members = slices.DeleteFunc(state.Members, func(m *Member) bool {
+			return m.DisconnectedAt != nil && m.User != nil && m.User.ID == c.User.ID
+		})
+		state.Members = append(state.Members, &Member{
+			ClientID:  c.ID,
+			User:      c.User,
+			JoinedAt:  time.Now(),
+			SessionID: session,
+		})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to add member to room %s: %v", roomID, err)
+		return false
+	}
+
+	c.Session = session
+	return isHost
+}
+
+// busMessage is what travels over the broker. To narrows delivery to one
+// user's connections, including ones still waiting to be let in; Admit
+// resolves such a wait; Close disconnects the room after delivery.
+type busMessage struct {
+	Event json.RawMessage `json:"event"`
+	To    string          `json:"to,omitempty"`
+	Admit *bool           `json:"admit,omitempty"`
+	Close bool            `json:"close,omitempty"`
 }
 
+// Broadcast publishes an event to every client of the room, on every instance,
+// and logs it so reconnecting clients can replay it
 func (h *Hub) Broadcast(roomID string, event Event) {
+	h.publish(roomID, h.logEvent(roomID, event), busMessage{})
+}
+
+// SendToUser publishes an event to every connection of one user in the room,
+// on every instance
+func (h *Hub) SendToUser(roomID, userID string, event Event) {
+	h.publish(roomID, event, busMessage{To: userID})
+}
+
+func (h *Hub) publish(roomID string, event Event, msg busMessage) {
+	data, err := encodeEvent(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+	msg.Event = data
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode bus message: %v", err)
+		return
+	}
+
+	if err := h.broker.Publish(context.Background(), roomID, payload); err != nil {
+		// Other instances miss this one, but our own clients still get it
+		log.Printf("Broker publish failed for room %s: %v", roomID, err)
+		h.deliverMessage(roomID, msg)
+	}
+}
+
+// Send queues an event for a single client
+func (h *Hub) Send(c *Client, event Event) {
+	data, err := encodeEvent(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+	c.sendFrame(newFrame(data))
+}
+
+// deliverEvent encodes and delivers an event to this process's clients only
+func (h *Hub) deliverEvent(roomID string, event Event) {
+	data, err := encodeEvent(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+	h.deliverMessage(roomID, busMessage{Event: data})
+}
+
+// localRooms lists the rooms that have clients connected to this process
+func (h *Hub) localRooms() []string {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	roomIDs := make([]string, 0, len(h.rooms))
+	for roomID := range h.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs
+}
+
+// deliver is the broker callback: it decodes a bus message and hands it to
+// the clients connected to this process
+func (h *Hub) deliver(roomID string, payload []byte) {
+	var msg busMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		log.Printf("Invalid bus message for room %s: %v", roomID, err)
+		return
+	}
+	h.deliverMessage(roomID, msg)
+}
+
+func (h *Hub) deliverMessage(roomID string, msg busMessage) {
 	h.mux.RLock()
 	room, ok := h.rooms[roomID]
 	if !ok {
@@ -82,122 +339,187 @@ func (h *Hub) Broadcast(roomID string, event Event) {
 		return
 	}
 
-	data, _ := json.Marshal(event)
 	failedClients := []*Client{}
+	// Encoded at most once per format, however many clients want it
+	f := newFrame(msg.Event)
 
 	for client := range room.clients {
-		if err := client.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Println("Broadcast error:", err)
+		if msg.To != "" && (client.User == nil || client.User.ID != msg.To) {
+			continue
+		}
+		if !client.sendFrame(f) {
 			failedClients = append(failedClients, client)
 		}
 	}
+	if msg.To != "" {
+		room.notifyPending(msg, f)
+	}
 	h.mux.RUnlock()
 
 	if len(failedClients) > 0 {
 		h.mux.Lock()
 		for _, client := range failedClients {
-			client.Conn.Close()
 			delete(room.clients, client)
 		}
 		h.mux.Unlock()
 	}
+
+	if msg.Close {
+		h.disconnectRoom(roomID)
+	}
 }
 
 // ---- Queue Management ----
 
-func (h *Hub) AddSong(roomID string, song Song) {
-	h.mux.Lock()
-	defer h.mux.Unlock()
-
-	if room, ok := h.rooms[roomID]; ok {
-		room.songsQueue = append(room.songsQueue, song)
-		if room.currentSongIdx == -1 {
-			room.currentSongIdx = 0
+// AddSong queues a song for the client, subject to the room's queue policy,
+// and returns where it went: the end, or anywhere upcoming while shuffling.
+// A *QueueRejection explains a song the policy turned away.
+func (h *Hub) AddSong(roomID string, c *Client, song Song) (added Song, index int, err error) {
+	h.resolveDuration(&song)
+	_, err = h.updateState(roomID, func(state *RoomState) error {
+		if err := state.checkQueuePolicy(song, c.User.ID); err != nil {
+			return err
+		}
+		state.stampQueued(&song, c.User)
+		index = state.insertIndex()
+		state.SongsQueue = slices.Insert(state.SongsQueue, index, song)
+		if state.CurrentSongIdx == -1 {
+			state.CurrentSongIdx = 0
 		}
+		return nil
+	})
+	if err != nil {
+		var rejection *QueueRejection
+		if !errors.As(err, &rejection) {
+			log.Printf("Failed to add song to room %s: %v", roomID, err)
+		}
+		return Song{}, -1, err
 	}
+
+	return song, index, nil
 }
 
-// Move to next song
-func (h *Hub) NextSong(roomID string) (nextSong Song, status bool) {
-	h.mux.Lock()
-	defer h.mux.Unlock()
+// stampQueued records who queued the song and when, dropping anything the
+// client claimed. AddedAt is kept unique within the queue so the order songs
+// were added in can always be recovered.
+func (s *RoomState) stampQueued(song *Song, user *User) {
+	addedAt := nowMs()
+	for _, queued := range s.SongsQueue {
+		addedAt = max(addedAt, queued.AddedAt+1)
+	}
 
-	room, ok := h.rooms[roomID]
-	if !ok || len(room.songsQueue) == 0 {
-		return Song{}, false
+	song.RequestedBy = user
+	song.AddedAt = addedAt
+	song.AutoAdded = false
+	song.Votes = nil
+}
+
+// SongFromYT turns a search result into a song ready to queue
+func SongFromYT(yt YTSong) Song {
+	return Song{
+		Title:     yt.Title,
+		VideoID:   yt.VideoID,
+		Artists:   yt.Artist,
+		Thumbnail: yt.Image,
+		Duration:  yt.Duration,
 	}
+}
+
+// startSong moves the cursor to idx and starts that song from the top
+func (s *RoomState) startSong(idx int) Song {
+	s.CurrentSongIdx = idx
+	s.SkipVotes = nil
+	song := s.SongsQueue[idx]
+	s.beginPlay(song, nowMs())
+	s.Playback.start(song.VideoID)
+	return song
+}
 
-	if room.currentSongIdx < len(room.songsQueue)-1 {
-		room.currentSongIdx++
-	} else {
-		return Song{}, false // end of queue
+// advance moves to the next song for the room's play mode; trackEnded says
+// whether the current song played out or is being skipped
+func (s *RoomState) advance(trackEnded bool) (Song, error) {
+	idx, ok := s.nextIndex(trackEnded)
+	if !ok {
+		return Song{}, errEndOfQueue
 	}
+	return s.startSong(idx), nil
+}
 
-	nextSong = room.songsQueue[room.currentSongIdx]
-	return nextSong, true
+// Move to next song
+func (h *Hub) NextSong(roomID string) (nextSong Song, status bool) {
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		var err error
+		nextSong, err = state.advance(false)
+		return err
+	})
+	h.autoplay(roomID)
+	if err != nil {
+		if !errors.Is(err, errEndOfQueue) {
+			log.Printf("Failed to move to next song in room %s: %v", roomID, err)
+		}
+		return Song{}, false
+	}
 
-	// return room.songsQueue[room.currentSongIdx], true
+	return nextSong, true
 }
 
 // Move to previous song
 func (h *Hub) PreviousSong(roomID string) (prevSong Song, status bool) {
-	h.mux.Lock()
-	defer h.mux.Unlock()
-
-	room, ok := h.rooms[roomID]
-	if !ok || len(room.songsQueue) == 0 {
+	_, err := h.updateState(roomID, func(state *RoomState) error {
+		idx, ok := state.previousIndex()
+		if !ok {
+			return errStartOfQueue
+		}
+		prevSong = state.startSong(idx)
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errStartOfQueue) {
+			log.Printf("Failed to move to previous song in room %s: %v", roomID, err)
+		}
 		return Song{}, false
 	}
 
-	if room.currentSongIdx > 0 {
-		room.currentSongIdx--
-	} else {
-		return Song{}, false // already at start
-	}
-
-	prevSong = room.songsQueue[room.currentSongIdx]
 	return prevSong, true
-	// return room.songsQueue[room.currentSongIdx], true
 }
 
 // Get current song
 func (h *Hub) CurrentSong(roomID string) (currSong Song, status bool) {
-	h.mux.RLock()
-	defer h.mux.RUnlock()
-
-	room, ok := h.rooms[roomID]
-	if !ok || room.currentSongIdx < 0 || room.currentSongIdx >= len(room.songsQueue) {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
 		return Song{}, false
 	}
 
-	currSong = room.songsQueue[room.currentSongIdx]
-	return currSong, true
-
-	// return room.songsQueue[room.currentSongIdx], true
+	return state.currentSong()
 }
 
 // ---- User Management ----
 
 // Get all users in a room
 func (h *Hub) GetRoomUsers(roomID string) []*User {
-	h.mux.RLock()
-	defer h.mux.RUnlock()
-
-	room, ok := h.rooms[roomID]
-	if !ok {
+	state, err := h.loadState(roomID)
+	if err != nil {
+		log.Printf("Failed to load room %s: %v", roomID, err)
 		return []*User{}
 	}
 
-	users := make([]*User, 0, len(room.clients))
-	for client := range room.clients {
-		if client.User != nil {
-			users = append(users, client.User)
-		}
+	users := make([]*User, 0, len(state.Members))
+	for _, m := range state.Members {
+		users = append(users, m.User)
 	}
 
 	return users
 }
 
+// SendUsers sends the user list to a single client
+func (h *Hub) SendUsers(c *Client) {
+	h.Send(c, Event{
+		Type:  "all_users",
+		Users: h.GetRoomUsers(c.Room),
+	})
+}
+
 // Broadcast user list to all clients in room
 func (h *Hub) BroadcastUsers(roomID string) {
 	users := h.GetRoomUsers(roomID)